@@ -6,6 +6,8 @@ import (
 	"net"
 	"testing"
 	"time"
+
+	"github.com/yourfavoritedev/go_networking/lossy"
 )
 
 func TestEchoServerUDP(t *testing.T) {
@@ -190,3 +192,61 @@ func TestDialUDP(t *testing.T) {
 		t.Fatal("unexpected packet")
 	}
 }
+
+func TestEchoServerUDPLossy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// initiate UDP server
+	serverAddr, err := echoServerUDP(ctx, "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client's pings are dropped half of the time and duplicated
+	// otherwise, so every reply arrives twice. With this seed the first
+	// few pings are dropped.
+	client := lossy.NewPacketConn(conn, lossy.Config{Seed: 2, Drop: 0.5, Duplicate: 1})
+	defer client.Close()
+
+	ping := []byte("ping")
+	buf := make([]byte, 1024)
+	replies := 0
+
+	// keep pinging until a ping gets through
+	for attempt := 1; replies == 0; attempt++ {
+		if attempt > 20 {
+			t.Fatal("no ping got through")
+		}
+
+		_, err = client.WriteTo(ping, serverAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, _, err := client.ReadFrom(buf)
+			if err != nil {
+				break // timed out; no more replies
+			}
+
+			if !bytes.Equal(ping, buf[:n]) {
+				t.Errorf("expected reply %q, actual reply %q", ping, buf[:n])
+			}
+			replies++
+		}
+	}
+
+	if replies != 2 {
+		t.Errorf("expected 2 replies to the duplicated ping, actual %d", replies)
+	}
+
+	if s := client.Stats(); s.Dropped == 0 {
+		t.Errorf("expected dropped pings: %+v", s)
+	}
+}
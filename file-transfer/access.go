@@ -0,0 +1,176 @@
+package filetransfer
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Permission is a set of operations allowed on a file.
+type Permission uint8
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+)
+
+// PathRule sets the permissions for the files matching Pattern. Pattern
+// syntax is that of path.Match; a pattern ending in a slash matches every
+// file below the directories it matches, so "*/" matches every file in a
+// subdirectory.
+type PathRule struct {
+	Pattern string
+	Perm    Permission
+}
+
+// Policy decides which clients may read or write which files and how many
+// transfers may run at once. The zero value allows everything.
+type Policy struct {
+	Allow []*net.IPNet // if not empty, only clients in these networks are served
+	Deny  []*net.IPNet // clients in these networks are refused, even if allowed
+
+	// Paths is searched in order and the first rule matching the
+	// normalized filename applies. Files no rule matches may be read and
+	// written. Writing also requires the server to have a WriteHandler.
+	Paths []PathRule
+
+	MaxTransfers      int // concurrent transfers in total; 0 means no limit
+	MaxTransfersPerIP int // concurrent transfers per client IP address; 0 means no limit
+}
+
+// ParseNetworks parses CIDR notation such as "192.0.2.0/24". A bare IP
+// address is a network of that single address.
+func ParseNetworks(cidrs ...string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+// authorize returns the ERROR packet refusing the client at ip access to
+// filename, or nil if perm is granted.
+func (p Policy) authorize(ip net.IP, filename string, perm Permission) *Err {
+	if contains(p.Deny, ip) || len(p.Allow) > 0 && !contains(p.Allow, ip) {
+		return &Err{Code: ErrAccessViolation, Message: "access denied"}
+	}
+
+	name, err := cleanPath(filename)
+	if err != nil {
+		e := errPacket(err)
+		return &e
+	}
+
+	if p.perm(name)&perm != perm {
+		return &Err{Code: ErrAccessViolation, Message: "access violation"}
+	}
+
+	return nil
+}
+
+// perm returns the permissions of the normalized filename name.
+func (p Policy) perm(name string) Permission {
+	for _, rule := range p.Paths {
+		if match(rule.Pattern, name) {
+			return rule.Perm
+		}
+	}
+
+	return PermRead | PermWrite
+}
+
+// match reports whether the normalized filename name matches pattern; see
+// PathRule.
+func match(pattern, name string) bool {
+	if !strings.HasSuffix(pattern, "/") {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+
+	// compare the leading directories of name with the pattern, element
+	// by element so wildcards don't cross a slash
+	dir := strings.TrimSuffix(pattern, "/")
+	depth := strings.Count(dir, "/") + 1
+
+	elems := strings.SplitN(name, "/", depth+1)
+	if len(elems) <= depth {
+		return false
+	}
+
+	ok, _ := path.Match(dir, strings.Join(elems[:depth], "/"))
+
+	return ok
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// limiter counts the transfers in progress.
+type limiter struct {
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// acquire counts a new transfer for the client at ip, unless it would
+// exceed max in total or maxPerIP for ip. Limits of 0 are ignored.
+func (l *limiter) acquire(ip net.IP, max, maxPerIP int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := ip.String()
+
+	if max > 0 && l.total >= max || maxPerIP > 0 && l.perIP[key] >= maxPerIP {
+		return false
+	}
+
+	if l.perIP == nil {
+		l.perIP = make(map[string]int)
+	}
+	l.total++
+	l.perIP[key]++
+
+	return true
+}
+
+// release ends a transfer counted by acquire.
+func (l *limiter) release(ip net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := ip.String()
+
+	l.total--
+	if l.perIP[key]--; l.perIP[key] == 0 {
+		delete(l.perIP, key)
+	}
+}
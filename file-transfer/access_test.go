@@ -0,0 +1,195 @@
+package filetransfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPolicy(t *testing.T) {
+	allow, err := ParseNetworks("192.0.2.0/24", "2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deny, err := ParseNetworks("192.0.2.66")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Policy{
+		Allow: allow,
+		Deny:  deny,
+		Paths: []PathRule{
+			{Pattern: "private/", Perm: 0},
+			{Pattern: "uploads/", Perm: PermRead | PermWrite},
+			{Pattern: "*/", Perm: PermRead},
+			{Pattern: "*.img", Perm: PermRead},
+		},
+	}
+
+	tests := []struct {
+		ip       string
+		filename string
+		perm     Permission
+		allowed  bool
+	}{
+		{"192.0.2.1", "boot.img", PermRead, true},
+		{"2001:db8::1", "boot.img", PermRead, true},
+		{"198.51.100.1", "boot.img", PermRead, false}, // not allowed
+		{"192.0.2.66", "boot.img", PermRead, false},   // denied
+		{"192.0.2.1", "boot.img", PermWrite, false},
+		{"192.0.2.1", "notes.txt", PermWrite, true}, // no rule matches
+		{"192.0.2.1", "private/key", PermRead, false},
+		{"192.0.2.1", "private/keys/a", PermRead, false},
+		{"192.0.2.1", "uploads/new.cfg", PermWrite, true},
+		{"192.0.2.1", `uploads\new.cfg`, PermWrite, true},
+		{"192.0.2.1", "pxelinux.cfg/default", PermRead, true},
+		{"192.0.2.1", "pxelinux.cfg/default", PermWrite, false},
+		{"192.0.2.1", "uploads/../private/key", PermRead, false},
+		{"192.0.2.1", "../etc/passwd", PermRead, false},
+	}
+
+	for _, test := range tests {
+		e := p.authorize(net.ParseIP(test.ip), test.filename, test.perm)
+		if test.allowed && e != nil {
+			t.Errorf("%s %s: unexpected refusal: %v", test.ip, test.filename, e)
+		}
+		if !test.allowed && (e == nil || e.Code != ErrAccessViolation) {
+			t.Errorf("%s %s: expected access violation; actual %v", test.ip, test.filename, e)
+		}
+	}
+
+	if _, err = ParseNetworks("192.0.2.0/33"); err == nil {
+		t.Error("expected error for invalid network")
+	}
+}
+
+func TestServerPolicy(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"firmware", "uploads"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.WriteFile(filepath.Join(root, "firmware", "v1.bin"), []byte("firmware"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serve(t, &Server{
+		Root:    DirFS(root),
+		Write:   DirWriter(root),
+		Timeout: time.Second,
+		Policy: Policy{
+			Paths: []PathRule{
+				{Pattern: "firmware/", Perm: PermRead},
+				{Pattern: "uploads/", Perm: PermWrite},
+			},
+		},
+	})
+	c := Client{Timeout: time.Second}
+
+	expectCode := func(err error, code ErrCode) {
+		t.Helper()
+
+		var e Err
+		if !errors.As(err, &e) || e.Code != code {
+			t.Errorf("expected error code %d; actual %v", code, err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err = c.Get(addr, "firmware/v1.bin", buf); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Put(addr, "firmware/v2.bin", bytes.NewReader([]byte("rogue")))
+	expectCode(err, ErrAccessViolation)
+
+	if _, err = c.Put(addr, "uploads/log.txt", bytes.NewReader([]byte("log"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// write-only files can't be read back, even though they exist
+	_, err = c.Get(addr, "uploads/log.txt", new(bytes.Buffer))
+	expectCode(err, ErrAccessViolation)
+
+	// denied clients are refused whatever they ask for
+	loopback, err := ParseNetworks("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr = serve(t, &Server{
+		Root:    DirFS(root),
+		Timeout: time.Second,
+		Policy:  Policy{Deny: loopback},
+	})
+
+	_, err = c.Get(addr, "firmware/v1.bin", new(bytes.Buffer))
+	expectCode(err, ErrAccessViolation)
+}
+
+func TestServerLimits(t *testing.T) {
+	release := make(chan struct{})
+
+	// the handler stalls every transfer until released
+	handler := HandlerFunc(func(w io.Writer, _ *ReadReq, _ net.Addr) {
+		_, _ = w.Write([]byte("started"))
+		<-release
+	})
+
+	s := &Server{
+		Handler: handler,
+		Timeout: time.Second,
+		Policy:  Policy{MaxTransfersPerIP: 1},
+	}
+	addr := serve(t, s)
+	c := Client{Timeout: time.Second}
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Get(addr, "first", new(bytes.Buffer))
+		done <- err
+	}()
+
+	// wait for the first transfer to be counted, which happens before it's
+	// registered
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.Transfers()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the first transfer to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err := c.Get(addr, "second", new(bytes.Buffer))
+
+	var e Err
+	if !errors.As(err, &e) || e.Code != ErrAccessViolation {
+		t.Fatalf("expected access violation; actual %v", err)
+	}
+
+	close(release)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the first transfer's slot is freed once the server sees the final
+	// ACK, which may be just after the client returns
+	for i := 0; i < 10; i++ {
+		if _, err = c.Get(addr, "third", new(bytes.Buffer)); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
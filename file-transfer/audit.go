@@ -0,0 +1,135 @@
+package filetransfer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// outcomes of a request in an AuditRecord
+const (
+	OutcomeOK      = "ok"      // the file was transferred
+	OutcomeRefused = "refused" // the server's policy or limits refused the request
+	OutcomeFailed  = "failed"  // the request failed, such as for a missing file or a timeout
+)
+
+// AuditRecord describes the outcome of a request.
+type AuditRecord struct {
+	Time     time.Time         `json:"time"`
+	Remote   string            `json:"remote"`
+	Write    bool              `json:"write"` // whether the client wrote the file rather than read it
+	Filename string            `json:"filename"`
+	Mode     string            `json:"mode"`
+	Options  map[string]string `json:"options,omitempty"`
+	Outcome  string            `json:"outcome"`
+	Code     ErrCode           `json:"error_code"` // only meaningful unless the outcome is OutcomeOK
+	Error    string            `json:"error,omitempty"`
+	Bytes    int64             `json:"bytes"`
+	Duration time.Duration     `json:"duration"`
+}
+
+// AuditSink records the outcome of every request a server receives.
+// Audit is called from many goroutines at once.
+type AuditSink interface {
+	Audit(rec AuditRecord) error
+}
+
+// AuditFile is an AuditSink that appends records to a file as JSON lines.
+// Once the file would grow beyond its maximum size, it is renamed with the
+// time as a suffix and a new file is started. Renamed files are kept.
+type AuditFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	f       *os.File
+	size    int64
+}
+
+// NewAuditFile opens the audit file at path, appending to it if it exists.
+// A maxSize of 0 or less disables rotation.
+func NewAuditFile(path string, maxSize int64) (*AuditFile, error) {
+	a := &AuditFile{path: path, maxSize: maxSize}
+
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *AuditFile) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	a.f, a.size = f, info.Size()
+
+	return nil
+}
+
+// Audit implements the AuditSink interface.
+func (a *AuditFile) Audit(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f == nil {
+		return errors.New("audit file closed")
+	}
+
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err = a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+
+	return err
+}
+
+// rotate renames the current file and starts a new one. The caller must
+// hold a.mu.
+func (a *AuditFile) rotate() error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	a.f = nil
+
+	suffix := time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(a.path, a.path+"."+suffix); err != nil {
+		return err
+	}
+
+	return a.open()
+}
+
+// Close closes the audit file.
+func (a *AuditFile) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f == nil {
+		return nil
+	}
+
+	err := a.f.Close()
+	a.f = nil
+
+	return err
+}
@@ -0,0 +1,177 @@
+package filetransfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// auditLog is an AuditSink that keeps records in memory.
+type auditLog struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (l *auditLog) Audit(rec AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, rec)
+
+	return nil
+}
+
+// wait returns the records once there are n of them.
+func (l *auditLog) wait(t *testing.T, n int) []AuditRecord {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		records := append([]AuditRecord(nil), l.records...)
+		l.mu.Unlock()
+
+		if len(records) >= n || time.Now().After(deadline) {
+			if len(records) != n {
+				t.Fatalf("expected %d records; actual %d", n, len(records))
+			}
+			return records
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerAudit(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "boot.img"), []byte("boot image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	audit := new(auditLog)
+	addr := serve(t, &Server{
+		Root:    DirFS(root),
+		Timeout: time.Second,
+		Audit:   audit,
+		Policy:  Policy{Paths: []PathRule{{Pattern: "secret", Perm: 0}}},
+	})
+
+	c := Client{Timeout: time.Second, WindowSize: 2}
+	for _, filename := range []string{"boot.img", "missing", "secret"} {
+		_, _ = c.Get(addr, filename, new(bytes.Buffer))
+	}
+
+	records := audit.wait(t, 3)
+	sort.Slice(records, func(i, j int) bool { return records[i].Filename < records[j].Filename })
+
+	expected := []struct {
+		filename string
+		outcome  string
+		code     ErrCode
+		bytes    int64
+	}{
+		{"boot.img", OutcomeOK, 0, int64(len("boot image"))},
+		{"missing", OutcomeFailed, ErrNotFound, 0},
+		{"secret", OutcomeRefused, ErrAccessViolation, 0},
+	}
+
+	for i, e := range expected {
+		r := records[i]
+		if r.Filename != e.filename || r.Outcome != e.outcome || r.Code != e.code || r.Bytes != e.bytes {
+			t.Errorf("expected %s %s, code %d, %d bytes; actual %+v", e.filename, e.outcome, e.code, e.bytes, r)
+		}
+		if r.Mode != ModeOctet || r.Options[OptWindowSize] != "2" || r.Remote == "" || r.Write {
+			t.Errorf("%s: incomplete record %+v", e.filename, r)
+		}
+	}
+}
+
+func TestAuditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	a, err := NewAuditFile(path, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		err = a.Audit(AuditRecord{Filename: "boot.img", Outcome: OutcomeOK, Bytes: int64(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("expected rotated files; actual %v", files)
+	}
+
+	// every record is in exactly one file, and no file exceeds the limit
+	seen := make(map[int64]bool)
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 500 {
+			t.Errorf("%s: %d bytes exceeds the limit", name, info.Size())
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			var rec AuditRecord
+			if err = json.Unmarshal(s.Bytes(), &rec); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if seen[rec.Bytes] {
+				t.Errorf("duplicate record %d", rec.Bytes)
+			}
+			seen[rec.Bytes] = true
+		}
+		_ = f.Close()
+	}
+
+	if len(seen) != n {
+		t.Errorf("expected %d records; actual %d", n, len(seen))
+	}
+
+	// reopening appends to the current file
+	a, err = NewAuditFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = a.Close() }()
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Audit(AuditRecord{Outcome: OutcomeOK}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() <= before.Size() {
+		t.Error("expected the record to be appended")
+	}
+}
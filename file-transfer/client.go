@@ -0,0 +1,375 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDigestMismatch means a download doesn't match the digest the server
+// sent for it.
+var ErrDigestMismatch = errors.New("SHA-256 digest mismatch")
+
+type Client struct {
+	Mode       string        // the transfer mode; defaults to octet
+	Retries    uint8         // the number of times to retry a failed transmission
+	Timeout    time.Duration // the initial duration to wait for the server; adapts to the round-trip time
+	WindowSize uint16        // the window size to request; 0 or 1 means lock-step
+	Rollover   uint16        // the block number following 65535, either 0 or 1
+	Hooks      Hooks         // called as transfers progress
+	Verify     bool          // verify downloads against the server's SHA-256 digest, which it must provide
+
+	// ListenPacket creates the socket for each transfer; defaults to net.ListenPacket
+	ListenPacket func(network, address string) (net.PacketConn, error)
+}
+
+// Get downloads filename from the server at addr and writes it to w. The
+// returned statistics count the bytes received from the server, which
+// differs from the number of bytes written to w in netascii mode.
+func (c Client) Get(addr, filename string, w io.Writer) (Stats, error) {
+	return c.get(addr, filename, w, 0, sha256.New())
+}
+
+// Resume downloads filename from the server at addr into the partial file
+// at path, creating it if need be. It asks the server to start at the size
+// of the partial file and appends the rest. If the server can't resume, the
+// part the client already has is downloaded again and discarded. Resuming
+// requires octet mode.
+func (c Client) Resume(addr, filename, path string) (_ Stats, err error) {
+	if c.Mode != "" && !strings.EqualFold(c.Mode, ModeOctet) {
+		return Stats{}, errors.New("resuming requires octet mode")
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer func() {
+		if cErr := f.Close(); err == nil {
+			err = cErr
+		}
+	}()
+
+	// the digest covers the whole file, so it starts with the partial
+	// file; either way, f ends up positioned at its end for appending
+	h := sha256.New()
+	var offset int64
+	if c.Verify {
+		offset, err = io.Copy(h, f)
+	} else {
+		offset, err = f.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return c.get(addr, filename, f, offset, h)
+}
+
+// get downloads filename from offset on and writes it to w. h holds the
+// digest of the bytes before offset.
+func (c Client) get(addr, filename string, w io.Writer, offset int64, h hash.Hash) (_ Stats, err error) {
+	t, err := c.dial(addr)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer t.close()
+
+	options := c.options()
+	if c.Verify {
+		options[OptSHA256] = "1"
+	}
+	if offset > 0 {
+		options[OptOffset] = strconv.FormatInt(offset, 10)
+	}
+
+	t.hooks = c.Hooks
+	t.info = TransferInfo{Remote: t.peer, Filename: filename, Mode: c.Mode, Options: options}
+	t.begin()
+	defer func() { t.end(err) }()
+
+	// the digest covers the file as written to w
+	if c.Verify {
+		w = io.MultiWriter(w, h)
+	}
+
+	var netascii *NetASCIIWriter
+	if strings.ToLower(c.Mode) == ModeNetASCII {
+		netascii = NewNetASCIIWriter(w)
+		w = netascii
+	}
+
+	req, err := ReadReq{
+		Filename: filename,
+		Mode:     c.Mode,
+		Options:  options,
+	}.MarshalBinary()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	pkt, err := t.request(req)
+	if err != nil {
+		return t.Stats(), err
+	}
+
+	var (
+		oack   OAck
+		errPkt Err
+		acked  time.Time
+	)
+
+	switch {
+	case oack.UnmarshalBinary(pkt) == nil:
+		if err = c.accept(oack, t, options); err != nil {
+			return t.Stats(), err
+		}
+
+		// acknowledge the options and wait for the first DATA packet
+		if err = t.ack(0); err != nil {
+			return t.Stats(), err
+		}
+		acked = time.Now()
+		pkt = nil
+	case errPkt.UnmarshalBinary(pkt) == nil:
+		return t.Stats(), errPkt
+	}
+
+	// the server sends the whole file if it didn't acknowledge the offset
+	if _, ok := oack[OptOffset]; offset > 0 && !ok {
+		w = &skipWriter{w: w, n: offset}
+	}
+
+	sum := oack[OptSHA256]
+	if c.Verify && sum == "" {
+		err = errors.New("server did not provide a digest")
+		_ = t.fail(Err{Code: ErrBadOption, Message: err.Error()})
+		return t.Stats(), err
+	}
+
+	err = t.receive(w, pkt, acked)
+	if err == nil && netascii != nil {
+		err = netascii.Flush()
+	}
+
+	if err == nil && c.Verify {
+		if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(actual, sum) {
+			err = fmt.Errorf("%w: expected %s, actual %s", ErrDigestMismatch, sum, actual)
+		}
+	}
+
+	return t.Stats(), err
+}
+
+// Put uploads the contents of r to the server at addr as filename. The
+// returned statistics count the bytes sent to the server, which differs
+// from the number of bytes read from r in netascii mode.
+func (c Client) Put(addr, filename string, r io.Reader) (_ Stats, err error) {
+	t, err := c.dial(addr)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer func() { _ = t.conn.Close() }()
+
+	options := c.options()
+
+	t.hooks = c.Hooks
+	t.info = TransferInfo{Remote: t.peer, Filename: filename, Mode: c.Mode, Options: options, Write: true}
+	t.begin()
+	defer func() { t.end(err) }()
+
+	if strings.ToLower(c.Mode) == ModeNetASCII {
+		r = NewNetASCIIReader(r)
+	}
+
+	req, err := WriteReq{
+		Filename: filename,
+		Mode:     c.Mode,
+		Options:  options,
+	}.MarshalBinary()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	pkt, err := t.request(req)
+	if err != nil {
+		return t.Stats(), err
+	}
+
+	var (
+		oack   OAck
+		ackPkt Ack
+		errPkt Err
+	)
+
+	// the server accepts the request with an OACK if it acknowledged any
+	// options, or else with ACK 0
+	switch {
+	case oack.UnmarshalBinary(pkt) == nil:
+		if err = c.accept(oack, t, options); err != nil {
+			return t.Stats(), err
+		}
+	case ackPkt.UnmarshalBinary(pkt) == nil && ackPkt == 0:
+	case errPkt.UnmarshalBinary(pkt) == nil:
+		return t.Stats(), errPkt
+	default:
+		err = errors.New("unexpected reply to write request")
+		_ = t.fail(Err{Code: ErrIllegalOp, Message: err.Error()})
+		return t.Stats(), err
+	}
+
+	err = t.send(r)
+
+	return t.Stats(), err
+}
+
+// skipWriter discards the first n bytes written to it and writes the rest
+// to w.
+type skipWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= s.n {
+		s.n -= int64(len(p))
+		return len(p), nil
+	}
+
+	skipped := int(s.n)
+	s.n = 0
+
+	n, err := s.w.Write(p[skipped:])
+
+	return skipped + n, err
+}
+
+// dial validates the client's settings and returns a transfer with the
+// server at addr on a new socket. The caller must close the socket.
+func (c *Client) dial(addr string) (*transfer, error) {
+	if c.Retries == 0 {
+		c.Retries = 10
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 6 * time.Second
+	}
+
+	if c.Mode == "" {
+		c.Mode = ModeOctet
+	}
+
+	switch strings.ToLower(c.Mode) {
+	case ModeOctet, ModeNetASCII:
+	default:
+		return nil, fmt.Errorf("unsupported mode %q", c.Mode)
+	}
+
+	if c.Rollover > 1 {
+		return nil, fmt.Errorf("invalid rollover %d", c.Rollover)
+	}
+
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	listen := net.ListenPacket
+	if c.ListenPacket != nil {
+		listen = c.ListenPacket
+	}
+
+	// use the server's address family
+	network := "udp"
+	switch {
+	case serverAddr.IP.To4() != nil:
+		network = "udp4"
+	case serverAddr.IP != nil:
+		network = "udp6"
+	}
+
+	conn, err := listen(network, ":0")
+	if err != nil {
+		return nil, err
+	}
+
+	return newTransfer(conn, serverAddr, c.Retries, c.Timeout), nil
+}
+
+// options returns the options to request.
+func (c Client) options() map[string]string {
+	options := make(map[string]string)
+
+	if c.WindowSize > 1 {
+		options[OptWindowSize] = strconv.Itoa(int(c.WindowSize))
+	}
+
+	if c.Rollover != 0 {
+		options[OptRollover] = strconv.Itoa(int(c.Rollover))
+	}
+
+	return options
+}
+
+// accept applies the options the server acknowledged to t. The server may
+// only acknowledge options the client requested. Unacceptable options are
+// refused with an ERROR packet.
+func (c Client) accept(oack OAck, t *transfer, requested map[string]string) error {
+	err := c.applyOptions(oack, t, requested)
+	if err != nil {
+		_ = t.fail(Err{Code: ErrBadOption, Message: err.Error()})
+	}
+
+	return err
+}
+
+func (c Client) applyOptions(oack OAck, t *transfer, requested map[string]string) error {
+	for name, v := range oack {
+		if _, ok := requested[name]; !ok {
+			return fmt.Errorf("unrequested option %q", name)
+		}
+
+		switch name {
+		case OptWindowSize:
+			size, err := parseWindowSize(v)
+			if err != nil {
+				return err
+			}
+
+			if c.WindowSize <= 1 || size > c.WindowSize {
+				return fmt.Errorf("unexpected window size %d", size)
+			}
+			t.windowSize = size
+		case OptRollover:
+			rollover, err := parseRollover(v)
+			if err != nil {
+				return err
+			}
+
+			if rollover != c.Rollover {
+				return fmt.Errorf("unexpected rollover %d", rollover)
+			}
+			t.rollover = rollover
+		case OptSHA256:
+			if sum, err := hex.DecodeString(v); err != nil || len(sum) != sha256.Size {
+				return fmt.Errorf("invalid digest %q", v)
+			}
+		case OptOffset:
+			if v != requested[OptOffset] {
+				return fmt.Errorf("unexpected offset %q", v)
+			}
+		default:
+			return fmt.Errorf("unrequested option %q", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,326 @@
+package filetransfer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientDuplicateData(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	type result struct {
+		stats Stats
+		err   error
+	}
+	done := make(chan result, 1)
+	buf := new(bytes.Buffer)
+
+	go func() {
+		stats, err := Client{Timeout: time.Second}.Get(server.LocalAddr().String(), "test", buf)
+		done <- result{stats, err}
+	}()
+
+	p := make([]byte, DatagramSize)
+	_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, client, err := server.ReadFrom(p) // the RRQ
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reply from a new transfer ID, like a real server
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	send := func(block uint16, payload string) {
+		pkt, _ := (&Data{Block: block - 1, Payload: bytes.NewReader([]byte(payload))}).MarshalBinary()
+		if _, err := conn.WriteTo(pkt, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectAck := func(block uint16) {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ack Ack
+		if err = ack.UnmarshalBinary(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		if uint16(ack) != block {
+			t.Fatalf("expected ACK %d; actual %d", block, ack)
+		}
+	}
+
+	first := string(bytes.Repeat([]byte("a"), BlockSize))
+
+	// block 1 arrives twice; both copies are acknowledged, since the first
+	// ACK may have been lost, but the payload is written once
+	send(1, first)
+	send(1, first)
+	expectAck(1)
+	expectAck(1)
+
+	// a stray packet from another transfer ID gets an error reply
+	stray, _ := Ack(1).MarshalBinary()
+	if _, err = server.WriteTo(stray, client); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e Err
+	if err = e.UnmarshalBinary(p[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != ErrUnknownID {
+		t.Fatalf("expected error code %d; actual %d", ErrUnknownID, e.Code)
+	}
+
+	send(2, "end")
+	expectAck(2)
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	if expected := first + "end"; buf.String() != expected {
+		t.Fatalf("expected %d bytes; actual %d", len(expected), buf.Len())
+	}
+}
+
+func TestClientPut(t *testing.T) {
+	root := t.TempDir()
+	addr := serve(t, &Server{Payload: []byte{}, Write: DirWriter(root), Timeout: time.Second})
+
+	payload := make([]byte, 50*BlockSize+7)
+	_, err := rand.Read(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Client{Timeout: time.Second, WindowSize: 8}
+	stats, err := c.Put(addr, "upload.bin", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bytes != int64(len(payload)) {
+		t.Errorf("expected %d bytes sent; actual %d", len(payload), stats.Bytes)
+	}
+
+	actual, err := os.ReadFile(filepath.Join(root, "upload.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload, actual) {
+		t.Errorf("expected %d bytes stored; actual %d", len(payload), len(actual))
+	}
+
+	// text is translated to netascii on the wire and back on the server
+	text := "line 1\nline 2\r\n"
+	_, err = Client{Timeout: time.Second, Mode: ModeNetASCII}.Put(addr, "notes.txt", strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err = os.ReadFile(filepath.Join(root, "notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != text {
+		t.Errorf("expected %q; actual %q", text, actual)
+	}
+
+	tests := []struct {
+		filename string
+		code     ErrCode
+	}{
+		{"upload.bin", ErrFileExists}, // never overwritten
+		{"missing/upload.bin", ErrNotFound},
+		{"../escape", ErrAccessViolation},
+	}
+
+	for _, test := range tests {
+		_, err = c.Put(addr, test.filename, bytes.NewReader(payload))
+
+		var e Err
+		if !errors.As(err, &e) || e.Code != test.code {
+			t.Errorf("%s: expected error code %d; actual %v", test.filename, test.code, err)
+		}
+	}
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 files; actual %d", len(entries))
+	}
+
+	// servers without a write handler refuse writes
+	addr = serve(t, &Server{Payload: []byte{}, Timeout: time.Second})
+
+	_, err = c.Put(addr, "upload.bin", bytes.NewReader(payload))
+
+	var e Err
+	if !errors.As(err, &e) || e.Code != ErrAccessViolation {
+		t.Errorf("expected access violation; actual %v", err)
+	}
+}
+
+func TestClientVerify(t *testing.T) {
+	root := t.TempDir()
+	text := "line 1\nline 2\r\n"
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := NewServeMux()
+	mux.Handle("notes.txt", FileServer(DirFS(root)))
+	mux.Handle("payload", payloadHandler("payload"))
+	mux.HandleFunc("wrong", func(w io.Writer, _ *ReadReq, _ net.Addr) {
+		SetDigest(w, make([]byte, sha256.Size))
+		_, _ = w.Write([]byte("tampered"))
+	})
+	mux.HandleFunc("none", func(w io.Writer, _ *ReadReq, _ net.Addr) {
+		_, _ = w.Write([]byte("unverifiable"))
+	})
+
+	addr := serve(t, &Server{Handler: mux, Timeout: time.Second})
+
+	for _, c := range []Client{
+		{Timeout: time.Second, Verify: true},
+		{Timeout: time.Second, Verify: true, Mode: ModeNetASCII, WindowSize: 4},
+	} {
+		buf := new(bytes.Buffer)
+		if _, err := c.Get(addr, "notes.txt", buf); err != nil {
+			t.Fatalf("%s: %v", c.Mode, err)
+		}
+		if buf.String() != text {
+			t.Errorf("%s: expected %q; actual %q", c.Mode, text, buf)
+		}
+	}
+
+	c := Client{Timeout: time.Second, Verify: true}
+	if _, err := c.Get(addr, "payload", new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.Get(addr, "wrong", new(bytes.Buffer))
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("expected digest mismatch; actual %v", err)
+	}
+
+	// a server that can't provide a digest is refused before the download
+	buf := new(bytes.Buffer)
+	_, err = c.Get(addr, "none", buf)
+	if err == nil || buf.Len() > 0 {
+		t.Errorf("expected refusal without a digest; actual %v and %d bytes", err, buf.Len())
+	}
+
+	// the digest is only sent on request
+	if _, err = (Client{Timeout: time.Second}).Get(addr, "wrong", new(bytes.Buffer)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientResume(t *testing.T) {
+	root := t.TempDir()
+
+	content := make([]byte, 5*BlockSize+100)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "fw.bin"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := NewServeMux()
+	mux.Handle("fw.bin", FileServer(DirFS(root)))
+	// a handler that knows nothing about offsets always sends everything
+	mux.HandleFunc("legacy.bin", func(w io.Writer, _ *ReadReq, _ net.Addr) {
+		_, _ = w.Write(content)
+	})
+
+	addr := serve(t, &Server{Handler: mux, Timeout: time.Second})
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		filename string
+		partial  int
+		received int64
+	}{
+		{"partial", "fw.bin", 1000, int64(len(content) - 1000)},
+		{"empty", "fw.bin", 0, int64(len(content))},
+		{"complete", "fw.bin", len(content), 0},
+		{"unsupported", "legacy.bin", 1000, int64(len(content))},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := os.WriteFile(path, content[:test.partial], 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		c := Client{Timeout: time.Second, WindowSize: 4, Verify: test.filename == "fw.bin"}
+		stats, err := c.Resume(addr, test.filename, path)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if stats.Bytes != test.received {
+			t.Errorf("%s: expected %d bytes received; actual %d", test.name, test.received, stats.Bytes)
+		}
+
+		actual, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, actual) {
+			t.Errorf("%s: expected %d bytes; actual %d", test.name, len(content), len(actual))
+		}
+	}
+
+	// a partial file longer than the file on the server can't be resumed
+	path := filepath.Join(dir, "longer")
+	if err := os.WriteFile(path, append(content, 'x'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Client{Timeout: time.Second}.Resume(addr, "fw.bin", path)
+
+	var e Err
+	if !errors.As(err, &e) || e.Code != ErrBadOption {
+		t.Errorf("expected bad option; actual %v", err)
+	}
+
+	if _, err = (Client{Mode: ModeNetASCII}).Resume(addr, "fw.bin", path); err == nil {
+		t.Error("expected error for netascii mode")
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"sort"
 	"strings"
 )
 
@@ -17,10 +20,25 @@ type OpCode uint16
 
 const (
 	OpRRQ OpCode = iota + 1
-	_            // no WRQ support
+	OpWRQ
 	OpData
 	OpAck
 	OpErr
+	OpOAck // option acknowledgment (RFC 2347)
+)
+
+// transfer modes
+const (
+	ModeOctet    = "octet"    // raw bytes
+	ModeNetASCII = "netascii" // text with CR LF line endings
+)
+
+// options negotiated through the RRQ or WRQ and OACK packets
+const (
+	OptWindowSize = "windowsize" // blocks in flight per acknowledgment (RFC 7440)
+	OptRollover   = "rollover"   // the block number following 65535, either 0 or 1
+	OptSHA256     = "sha256"     // requested with "1"; acknowledged with the hex-encoded SHA-256 of the file
+	OptOffset     = "offset"     // the byte offset to start the file at, in octet mode only
 )
 
 type ErrCode uint16
@@ -34,15 +52,46 @@ const (
 	ErrUnknownID
 	ErrFileExists
 	ErrNoUser
+	ErrBadOption // option negotiation failed (RFC 2347)
 )
 
 type ReadReq struct {
 	Filename string
 	Mode     string
+	Options  map[string]string // option names are case-insensitive and kept in lower case
 }
 
 func (q ReadReq) MarshalBinary() ([]byte, error) {
-	mode := "octet"
+	return requestPacket(q).marshal(OpRRQ)
+}
+
+func (q *ReadReq) UnmarshalBinary(p []byte) error {
+	return (*requestPacket)(q).unmarshal(OpRRQ, p)
+}
+
+type WriteReq struct {
+	Filename string
+	Mode     string
+	Options  map[string]string // option names are case-insensitive and kept in lower case
+}
+
+func (q WriteReq) MarshalBinary() ([]byte, error) {
+	return requestPacket(q).marshal(OpWRQ)
+}
+
+func (q *WriteReq) UnmarshalBinary(p []byte) error {
+	return (*requestPacket)(q).unmarshal(OpWRQ, p)
+}
+
+// requestPacket is the layout shared by RRQ and WRQ packets.
+type requestPacket struct {
+	Filename string
+	Mode     string
+	Options  map[string]string
+}
+
+func (q requestPacket) marshal(op OpCode) ([]byte, error) {
+	mode := ModeOctet
 	if q.Mode != "" {
 		mode = q.Mode
 	}
@@ -53,7 +102,7 @@ func (q ReadReq) MarshalBinary() ([]byte, error) {
 	b := new(bytes.Buffer)
 	b.Grow(cap)
 
-	err := binary.Write(b, binary.BigEndian, OpRRQ) // write operation code
+	err := binary.Write(b, binary.BigEndian, op) // write operation code
 	if err != nil {
 		return nil, err
 	}
@@ -78,10 +127,20 @@ func (q ReadReq) MarshalBinary() ([]byte, error) {
 		return nil, err
 	}
 
+	err = writeOptions(b, q.Options) // write options, if any
+	if err != nil {
+		return nil, err
+	}
+
 	return b.Bytes(), nil
 }
 
-func (q *ReadReq) UnmarshalBinary(p []byte) error {
+func (q *requestPacket) unmarshal(op OpCode, p []byte) error {
+	invalid := errors.New("invalid RRQ")
+	if op == OpWRQ {
+		invalid = errors.New("invalid WRQ")
+	}
+
 	r := bytes.NewBuffer(p)
 
 	var code OpCode
@@ -91,48 +150,60 @@ func (q *ReadReq) UnmarshalBinary(p []byte) error {
 		return err
 	}
 
-	if code != OpRRQ {
-		return errors.New("invalid RRQ")
+	if code != op {
+		return invalid
 	}
 
 	q.Filename, err = r.ReadString(0) // read filename
 	if err != nil {
-		return errors.New("invalid RRQ")
+		return invalid
 	}
 
 	q.Filename = strings.TrimRight(q.Filename, "\x00") // remove the 0-byte
 	if len(q.Filename) == 0 {
-		return errors.New("invalid RRQ")
+		return invalid
 	}
 
 	q.Mode, err = r.ReadString(0) // read mode
 	if err != nil {
-		return errors.New("invalid RRQ")
+		return invalid
 	}
 
 	q.Mode = strings.TrimRight(q.Mode, "\x00") // remove the 0-byte
 	if len(q.Mode) == 0 {
-		return errors.New("invalid RRQ")
+		return invalid
+	}
+
+	switch strings.ToLower(q.Mode) { // enforce a supported mode
+	case ModeOctet, ModeNetASCII:
+	default:
+		return errors.New("only octet and netascii transfers supported")
 	}
 
-	actual := strings.ToLower(q.Mode) // enforce octet mode
-	if actual != "octet" {
-		return errors.New("only binary transfers supported")
+	q.Options, err = readOptions(r) // read the remaining name/value pairs
+	if err != nil {
+		return invalid
 	}
 
 	return nil
 }
 
 type Data struct {
-	Block   uint16
-	Payload io.Reader
+	Block    uint16
+	Payload  io.Reader
+	Rollover uint16 // the block number following 65535, either 0 or 1
 }
 
 func (d *Data) MarshalBinary() ([]byte, error) {
 	b := new(bytes.Buffer)
 	b.Grow(DatagramSize)
 
-	d.Block++ // block numbers increment from 1
+	// block numbers increment from 1 and wrap around to the rollover value
+	if d.Block == math.MaxUint16 {
+		d.Block = d.Rollover
+	} else {
+		d.Block++
+	}
 
 	err := binary.Write(b, binary.BigEndian, OpData) // write operation code
 	if err != nil {
@@ -216,3 +287,170 @@ func (a *Ack) UnmarshalBinary(p []byte) error {
 
 	return binary.Read(r, binary.BigEndian, a) // read block number
 }
+
+type Err struct {
+	Code    ErrCode
+	Message string
+}
+
+// Error implements the error interface so that an error packet received
+// from the peer can be returned as is.
+func (e Err) Error() string {
+	return fmt.Sprintf("tftp error %d: %s", e.Code, e.Message)
+}
+
+func (e Err) MarshalBinary() ([]byte, error) {
+	// operation code + error code + message + 0 byte
+	cap := 2 + 2 + len(e.Message) + 1
+
+	b := new(bytes.Buffer)
+	b.Grow(cap)
+
+	err := binary.Write(b, binary.BigEndian, OpErr) // write operation code
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(b, binary.BigEndian, e.Code) // write error code
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = b.WriteString(e.Message) // write message
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.WriteByte(0) // write 0 byte
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func (e *Err) UnmarshalBinary(p []byte) error {
+	r := bytes.NewBuffer(p)
+
+	var code OpCode
+
+	err := binary.Read(r, binary.BigEndian, &code) // read operation code
+	if err != nil {
+		return err
+	}
+
+	if code != OpErr {
+		return errors.New("invalid ERROR")
+	}
+
+	err = binary.Read(r, binary.BigEndian, &e.Code) // read error code
+	if err != nil {
+		return err
+	}
+
+	e.Message, err = r.ReadString(0) // read message
+	if err != nil {
+		return errors.New("invalid ERROR")
+	}
+
+	e.Message = strings.TrimRight(e.Message, "\x00") // remove the 0-byte
+
+	return nil
+}
+
+// OAck holds the options the server accepted from a request (RFC 2347).
+type OAck map[string]string
+
+func (o OAck) MarshalBinary() ([]byte, error) {
+	b := new(bytes.Buffer)
+	b.Grow(DatagramSize)
+
+	err := binary.Write(b, binary.BigEndian, OpOAck) // write operation code
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeOptions(b, o) // write accepted options
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func (o *OAck) UnmarshalBinary(p []byte) error {
+	r := bytes.NewBuffer(p)
+
+	var code OpCode
+
+	err := binary.Read(r, binary.BigEndian, &code) // read operation code
+	if err != nil {
+		return err
+	}
+
+	if code != OpOAck {
+		return errors.New("invalid OACK")
+	}
+
+	opts, err := readOptions(r) // read accepted options
+	if err != nil {
+		return errors.New("invalid OACK")
+	}
+
+	*o = opts
+
+	return nil
+}
+
+// writeOptions writes each option as a 0-terminated name followed by its
+// 0-terminated value. Options are sorted by name so the output is stable.
+func writeOptions(b *bytes.Buffer, opts map[string]string) error {
+	names := make([]string, 0, len(opts))
+	for name := range opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, s := range []string{name, opts[name]} {
+			if _, err := b.WriteString(s); err != nil {
+				return err
+			}
+			if err := b.WriteByte(0); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readOptions reads 0-terminated name/value pairs until r is drained.
+// It returns nil if there are no options.
+func readOptions(r *bytes.Buffer) (map[string]string, error) {
+	var opts map[string]string
+
+	for r.Len() > 0 {
+		name, err := r.ReadString(0) // read option name
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := r.ReadString(0) // read option value
+		if err != nil {
+			return nil, err
+		}
+
+		name = strings.ToLower(strings.TrimRight(name, "\x00"))
+		if len(name) == 0 {
+			return nil, errors.New("empty option name")
+		}
+
+		if opts == nil {
+			opts = make(map[string]string)
+		}
+		opts[name] = strings.TrimRight(value, "\x00")
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,110 @@
+package filetransfer
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReadReqOptions(t *testing.T) {
+	expected := ReadReq{
+		Filename: "pxelinux.0",
+		Mode:     "octet",
+		Options:  map[string]string{OptWindowSize: "16"},
+	}
+
+	b, err := expected.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual ReadReq
+	err = actual.UnmarshalBinary(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %+v, actual %+v", expected, actual)
+	}
+
+	// option names are case-insensitive
+	b = append(b[:len(b)-len("windowsize\x0016\x00")], "WindowSize\x008\x00"...)
+	err = actual.UnmarshalBinary(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := actual.Options[OptWindowSize]; v != "8" {
+		t.Errorf("expected window size 8, actual %q", v)
+	}
+
+	// a name without a value is malformed
+	err = actual.UnmarshalBinary(append(b, "blksize\x00"...))
+	if err == nil {
+		t.Error("expected error for option without a value")
+	}
+}
+
+func TestOAck(t *testing.T) {
+	expected := OAck{OptWindowSize: "4"}
+
+	b, err := expected.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual OAck
+	err = actual.UnmarshalBinary(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+}
+
+func TestErr(t *testing.T) {
+	expected := Err{Code: ErrNotFound, Message: "no such file"}
+
+	b, err := expected.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual Err
+	err = actual.UnmarshalBinary(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected != actual {
+		t.Errorf("expected %+v, actual %+v", expected, actual)
+	}
+}
+
+func TestDataRollover(t *testing.T) {
+	for _, rollover := range []uint16{0, 1} {
+		d := &Data{
+			Block:    65535,
+			Payload:  bytes.NewReader([]byte("wrap")),
+			Rollover: rollover,
+		}
+
+		b, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var actual Data
+		err = actual.UnmarshalBinary(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual.Block != rollover {
+			t.Errorf("expected block %d after 65535; actual %d", rollover, actual.Block)
+		}
+	}
+}
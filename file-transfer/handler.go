@@ -0,0 +1,233 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Handler responds to a read request by writing the requested file to w.
+// It may call Error to refuse the request or to abort the transfer.
+type Handler interface {
+	ServeTFTP(w io.Writer, req *ReadReq, remote net.Addr)
+}
+
+// WriteHandler accepts a write request by returning where to store the
+// file, or refuses it by returning an error; see errPacket for how errors
+// are reported to the client. The server closes the writer once the
+// transfer completes. If the transfer fails and the writer has an
+// Abort() error method, the server calls it instead of Close so partial
+// files can be discarded.
+type WriteHandler interface {
+	CreateTFTP(req *WriteReq, remote net.Addr) (io.WriteCloser, error)
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(w io.Writer, req *ReadReq, remote net.Addr)
+
+func (f HandlerFunc) ServeTFTP(w io.Writer, req *ReadReq, remote net.Addr) {
+	f(w, req, remote)
+}
+
+// Error replies to the request with an ERROR packet instead of the rest of
+// the file. w must be the writer passed to ServeTFTP. The handler should not
+// write to w afterwards.
+func Error(w io.Writer, code ErrCode, message string) {
+	if rw, ok := w.(*responseWriter); ok {
+		_ = rw.pw.CloseWithError(Err{Code: code, Message: message})
+	}
+}
+
+// SetDigest sets the SHA-256 digest of the file, which the server sends to
+// clients that request it. w must be the writer passed to ServeTFTP, and
+// SetDigest must be called before the first write to w.
+func SetDigest(w io.Writer, sum []byte) {
+	setOption(w, OptSHA256, hex.EncodeToString(sum))
+}
+
+// SetOffset confirms that the handler writes the file starting at offset,
+// the offset the client requested. Otherwise the server doesn't acknowledge
+// the offset and the client expects the whole file. Offsets only apply to
+// octet mode. w must be the writer passed to ServeTFTP, and SetOffset must
+// be called before the first write to w.
+func SetOffset(w io.Writer, offset int64) {
+	setOption(w, OptOffset, strconv.FormatInt(offset, 10))
+}
+
+func setOption(w io.Writer, name, value string) {
+	if rw, ok := w.(*responseWriter); ok {
+		rw.mu.Lock()
+		defer rw.mu.Unlock()
+
+		if rw.options == nil {
+			rw.options = make(OAck)
+		}
+		rw.options[name] = value
+	}
+}
+
+// NotFound replies to the request with a file not found error.
+func NotFound(w io.Writer, _ *ReadReq, _ net.Addr) {
+	Error(w, ErrNotFound, "file not found")
+}
+
+// responseWriter is the writer a Handler writes the file to.
+type responseWriter struct {
+	pw *io.PipeWriter
+
+	mu      sync.Mutex
+	options OAck // options set by SetDigest and SetOffset
+}
+
+// option returns the value of the option name set by the handler, if any.
+func (rw *responseWriter) option(name string) (string, bool) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	v, ok := rw.options[name]
+
+	return v, ok
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	return rw.pw.Write(p)
+}
+
+// payloadHandler serves the same payload for every read request.
+type payloadHandler []byte
+
+func (p payloadHandler) ServeTFTP(w io.Writer, req *ReadReq, _ net.Addr) {
+	if _, ok := req.Options[OptSHA256]; ok {
+		sum := sha256.Sum256(p)
+		SetDigest(w, sum[:])
+	}
+
+	if offset, ok := requestedOffset(req); ok {
+		if offset > int64(len(p)) {
+			Error(w, ErrBadOption, "offset beyond end of file")
+			return
+		}
+		SetOffset(w, offset)
+		p = p[offset:]
+	}
+
+	_, _ = w.Write(p)
+}
+
+// ServeMux routes read requests to the handler registered for the first
+// pattern that matches the requested filename. A pattern without wildcards
+// matches only that filename and takes precedence over wildcard patterns,
+// which are tried in the order they were registered. Pattern syntax is that
+// of path.Match. Filenames are matched after normalization, so a request
+// for "/pxelinux.cfg/default" is refused before it is routed.
+type ServeMux struct {
+	mu       sync.RWMutex
+	exact    map[string]Handler
+	patterns []muxEntry
+}
+
+type muxEntry struct {
+	pattern string
+	handler Handler
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{exact: make(map[string]Handler)}
+}
+
+// Handle registers handler for pattern. It panics if pattern is malformed
+// or already registered.
+func (mux *ServeMux) Handle(pattern string, handler Handler) {
+	if handler == nil {
+		panic("filetransfer: nil handler")
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("filetransfer: invalid pattern %q: %v", pattern, err))
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if _, ok := mux.exact[pattern]; ok {
+		panic(fmt.Sprintf("filetransfer: multiple registrations for %q", pattern))
+	}
+	for _, e := range mux.patterns {
+		if e.pattern == pattern {
+			panic(fmt.Sprintf("filetransfer: multiple registrations for %q", pattern))
+		}
+	}
+
+	if hasMeta(pattern) {
+		mux.patterns = append(mux.patterns, muxEntry{pattern, handler})
+		return
+	}
+	mux.exact[pattern] = handler
+}
+
+// HandleFunc registers the handler function for pattern.
+func (mux *ServeMux) HandleFunc(pattern string, handler func(io.Writer, *ReadReq, net.Addr)) {
+	mux.Handle(pattern, HandlerFunc(handler))
+}
+
+// Handler returns the handler for filename, which is NotFound if no
+// pattern matches.
+func (mux *ServeMux) Handler(filename string) Handler {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	if h, ok := mux.exact[filename]; ok {
+		return h
+	}
+
+	for _, e := range mux.patterns {
+		if ok, _ := path.Match(e.pattern, filename); ok {
+			return e.handler
+		}
+	}
+
+	return HandlerFunc(NotFound)
+}
+
+func (mux *ServeMux) ServeTFTP(w io.Writer, req *ReadReq, remote net.Addr) {
+	name, err := cleanPath(req.Filename)
+	if err != nil {
+		e := errPacket(err)
+		Error(w, e.Code, e.Message)
+		return
+	}
+
+	mux.Handler(name).ServeTFTP(w, req, remote)
+}
+
+// requestedOffset returns the offset req asks the file to start at, if
+// any. Offsets only apply to octet mode.
+func requestedOffset(req *ReadReq) (int64, bool) {
+	v, ok := req.Options[OptOffset]
+	if !ok || !strings.EqualFold(req.Mode, ModeOctet) {
+		return 0, false
+	}
+
+	offset, err := parseOffset(v)
+
+	return offset, err == nil
+}
+
+// hasMeta reports whether pattern contains any of the special characters
+// recognized by path.Match.
+func hasMeta(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[', '\\':
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package filetransfer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeMux(t *testing.T) {
+	mux := NewServeMux()
+
+	// generate a per-host config
+	mux.HandleFunc("pxelinux.cfg/*", func(w io.Writer, req *ReadReq, remote net.Addr) {
+		host, _, _ := net.SplitHostPort(remote.String())
+		_, _ = fmt.Fprintf(w, "config for %s\n", host)
+	})
+
+	// the exact pattern wins over the wildcard registered before it
+	mux.HandleFunc("pxelinux.cfg/default", func(w io.Writer, _ *ReadReq, _ net.Addr) {
+		_, _ = io.WriteString(w, "default config\n")
+	})
+
+	mux.HandleFunc("denied/*", func(w io.Writer, _ *ReadReq, _ net.Addr) {
+		Error(w, ErrAccessViolation, "go away")
+	})
+
+	// fail after several blocks have been sent
+	mux.HandleFunc("broken", func(w io.Writer, _ *ReadReq, _ net.Addr) {
+		_, _ = w.Write(make([]byte, 3*BlockSize))
+		Error(w, ErrDiskFull, "ran out of data")
+	})
+
+	big := strings.Repeat("0123456789", 1000)
+	mux.Handle("big", HandlerFunc(func(w io.Writer, _ *ReadReq, _ net.Addr) {
+		// many small writes
+		for i := 0; i < len(big); i += 10 {
+			_, _ = io.WriteString(w, big[i:i+10])
+		}
+	}))
+
+	addr := serve(t, &Server{Handler: mux, Timeout: time.Second})
+	c := Client{Timeout: time.Second, WindowSize: 8}
+
+	tests := []struct {
+		filename string
+		content  string
+		code     ErrCode
+	}{
+		{filename: "pxelinux.cfg/01-aa-bb-cc-dd-ee-ff", content: "config for 127.0.0.1\n"},
+		{filename: "pxelinux.cfg/default", content: "default config\n"},
+		{filename: "pxelinux.cfg/../pxelinux.cfg/default", content: "default config\n"},
+		{filename: "big", content: big},
+		{filename: "pxelinux.cfg", code: ErrNotFound},
+		{filename: "other", code: ErrNotFound},
+		{filename: "/pxelinux.cfg/default", code: ErrAccessViolation},
+		{filename: "denied/file", code: ErrAccessViolation},
+		{filename: "broken", code: ErrDiskFull},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		_, err := c.Get(addr, test.filename, buf)
+
+		if test.content != "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.filename, err)
+			} else if buf.String() != test.content {
+				t.Errorf("%s: expected %q, actual %q", test.filename, test.content, buf)
+			}
+			continue
+		}
+
+		var e Err
+		if !errors.As(err, &e) {
+			t.Errorf("%s: expected error packet, actual %v", test.filename, err)
+			continue
+		}
+
+		if e.Code != test.code {
+			t.Errorf("%s: expected error code %d, actual %d", test.filename, test.code, e.Code)
+		}
+	}
+}
+
+func TestServeMuxDuplicatePattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for duplicate pattern")
+		}
+	}()
+
+	mux := NewServeMux()
+	mux.HandleFunc("*.cfg", NotFound)
+	mux.HandleFunc("*.cfg", NotFound)
+}
@@ -0,0 +1,220 @@
+package filetransfer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yourfavoritedev/go_networking/lossy"
+)
+
+// lossyListener returns a ListenPacket function whose sockets impair the
+// datagrams they send according to cfg. Each socket gets the next seed.
+func lossyListener(cfg lossy.Config) (func(network, address string) (net.PacketConn, error), func() lossy.Stats) {
+	var (
+		mu    sync.Mutex
+		conns []*lossy.PacketConn
+	)
+
+	listen := func(network, address string) (net.PacketConn, error) {
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		cfg.Seed++
+		c := lossy.NewPacketConn(conn, cfg)
+		conns = append(conns, c)
+
+		return c, nil
+	}
+
+	// stats totals the impairments of all sockets
+	stats := func() lossy.Stats {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var total lossy.Stats
+		for _, c := range conns {
+			s := c.Stats()
+			total.Dropped += s.Dropped
+			total.Duplicated += s.Duplicated
+			total.Delayed += s.Delayed
+			total.Reordered += s.Reordered
+			total.Corrupted += s.Corrupted
+		}
+		return total
+	}
+
+	return listen, stats
+}
+
+func TestTransferLossy(t *testing.T) {
+	payload := make([]byte, 100*BlockSize+42)
+	_, err := rand.Read(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  lossy.Config
+	}{
+		{"drop", lossy.Config{Drop: 0.05}},
+		{"duplicate", lossy.Config{Duplicate: 0.2}},
+		{"delay", lossy.Config{Delay: 0.2, MaxDelay: 5 * time.Millisecond}},
+		{"reorder", lossy.Config{Reorder: 0.1}},
+		// TFTP relies on the UDP checksum to discard damaged payloads and
+		// block numbers, so only the operation codes are corrupted here to
+		// prove that malformed packets are discarded
+		{"corrupt", lossy.Config{Corrupt: 0.05, CorruptLen: 2}},
+		{"everything", lossy.Config{
+			Drop:       0.02,
+			Duplicate:  0.05,
+			Delay:      0.05,
+			MaxDelay:   5 * time.Millisecond,
+			Reorder:    0.05,
+			Corrupt:    0.02,
+			CorruptLen: 2,
+		}},
+	}
+
+	for _, test := range tests {
+		for _, window := range []uint16{1, 8} {
+			test, window := test, window
+			t.Run(fmt.Sprintf("%s window %d", test.name, window), func(t *testing.T) {
+				t.Parallel()
+
+				serverListen, serverStats := lossyListener(test.cfg)
+				addr := serve(t, &Server{
+					Payload:      payload,
+					Timeout:      time.Second,
+					ListenPacket: serverListen,
+				})
+
+				cfg := test.cfg
+				cfg.Seed = 1000
+				clientListen, clientStats := lossyListener(cfg)
+				c := Client{
+					Timeout:      time.Second,
+					WindowSize:   window,
+					ListenPacket: clientListen,
+				}
+
+				buf := new(bytes.Buffer)
+				stats, err := c.Get(addr, "test", buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(payload, buf.Bytes()) {
+					t.Fatalf("received %d bytes; expected %d", stats.Bytes, len(payload))
+				}
+
+				t.Logf("server %+v, client %+v, %d retransmits",
+					serverStats(), clientStats(), stats.Retransmits)
+			})
+		}
+	}
+}
+
+// dropFinalAck is a socket that drops the first ACK of the final block.
+type dropFinalAck struct {
+	net.PacketConn
+
+	mu      sync.Mutex
+	final   Ack
+	dropped bool
+}
+
+func (d *dropFinalAck) WriteTo(p []byte, addr net.Addr) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var ack Ack
+	if !d.dropped && ack.UnmarshalBinary(p) == nil && ack == d.final {
+		d.dropped = true
+		return len(p), nil
+	}
+
+	return d.PacketConn.WriteTo(p, addr)
+}
+
+func TestTransferLostFinalAck(t *testing.T) {
+	payload := make([]byte, 10*BlockSize+42)
+	_, err := rand.Read(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the receiver's socket drops the final ACK once
+	listen := func(network, address string) (net.PacketConn, error) {
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		return &dropFinalAck{PacketConn: conn, final: 11}, nil
+	}
+
+	t.Run("get", func(t *testing.T) {
+		done := make(chan error, 1)
+		addr := serve(t, &Server{
+			Payload: payload,
+			Retries: 3,
+			Timeout: 200 * time.Millisecond,
+			Hooks:   Hooks{Done: func(_ TransferInfo, err error) { done <- err }},
+		})
+
+		buf := new(bytes.Buffer)
+		c := Client{Timeout: 200 * time.Millisecond, ListenPacket: listen}
+		if _, err := c.Get(addr, "test", buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(payload, buf.Bytes()) {
+			t.Fatalf("received %d bytes; expected %d", buf.Len(), len(payload))
+		}
+
+		// the server resends the final block and gets the ACK again
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("expected the server's transfer to succeed; actual %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("timed out waiting for the server's transfer")
+		}
+	})
+
+	t.Run("put", func(t *testing.T) {
+		root := t.TempDir()
+		addr := serve(t, &Server{
+			Payload:      []byte{},
+			Write:        DirWriter(root),
+			Timeout:      200 * time.Millisecond,
+			ListenPacket: listen,
+		})
+
+		c := Client{Retries: 3, Timeout: 200 * time.Millisecond}
+		if _, err := c.Put(addr, "upload.bin", bytes.NewReader(payload)); err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := os.ReadFile(filepath.Join(root, "upload.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(payload, actual) {
+			t.Errorf("expected %d bytes stored; actual %d", len(payload), len(actual))
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package filetransfer
+
+import (
+	"io"
+)
+
+// NewNetASCIIReader returns a reader that translates the text read from r
+// to netascii: LF becomes CR LF and a bare CR becomes CR NUL.
+func NewNetASCIIReader(r io.Reader) io.Reader {
+	return &netasciiReader{r: r}
+}
+
+type netasciiReader struct {
+	r       io.Reader
+	buf     []byte
+	pending []byte // translated bytes not yet returned
+}
+
+func (n *netasciiReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if len(n.pending) == 0 {
+		if cap(n.buf) < len(p) {
+			n.buf = make([]byte, len(p))
+		}
+
+		c, err := n.r.Read(n.buf[:len(p)])
+		if c == 0 {
+			return 0, err
+		}
+
+		n.pending = n.pending[:0]
+		for _, b := range n.buf[:c] {
+			switch b {
+			case '\n':
+				n.pending = append(n.pending, '\r', '\n')
+			case '\r':
+				n.pending = append(n.pending, '\r', 0)
+			default:
+				n.pending = append(n.pending, b)
+			}
+		}
+	}
+
+	c := copy(p, n.pending)
+	n.pending = n.pending[c:]
+
+	return c, nil
+}
+
+// NetASCIIWriter translates netascii written to it back to local text: CR LF
+// becomes LF and CR NUL becomes CR. A CR followed by anything else is kept
+// as is.
+type NetASCIIWriter struct {
+	w   io.Writer
+	cr  bool // whether the last byte written was a CR
+	out []byte
+}
+
+func NewNetASCIIWriter(w io.Writer) *NetASCIIWriter {
+	return &NetASCIIWriter{w: w}
+}
+
+// Write implements the io.Writer interface. A trailing CR is held back
+// until the next Write or Flush since it depends on the byte after it.
+func (n *NetASCIIWriter) Write(p []byte) (int, error) {
+	n.out = n.out[:0]
+
+	for _, b := range p {
+		if n.cr {
+			n.cr = false
+
+			switch b {
+			case '\n':
+				n.out = append(n.out, '\n')
+				continue
+			case 0:
+				n.out = append(n.out, '\r')
+				continue
+			default:
+				n.out = append(n.out, '\r')
+			}
+		}
+
+		if b == '\r' {
+			n.cr = true
+			continue
+		}
+
+		n.out = append(n.out, b)
+	}
+
+	if _, err := n.w.Write(n.out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush writes a held back CR, if any. Call it once all netascii has been
+// written.
+func (n *NetASCIIWriter) Flush() error {
+	if !n.cr {
+		return nil
+	}
+	n.cr = false
+
+	_, err := n.w.Write([]byte{'\r'})
+
+	return err
+}
@@ -0,0 +1,70 @@
+package filetransfer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+var netasciiTests = []struct {
+	text     string
+	netascii string
+}{
+	{"", ""},
+	{"plain", "plain"},
+	{"line 1\nline 2\n", "line 1\r\nline 2\r\n"},
+	{"bare\rcr", "bare\r\x00cr"},
+	{"\r\n", "\r\x00\r\n"},
+	{"\r\r\n\n", "\r\x00\r\x00\r\n\r\n"},
+	{"trailing\r", "trailing\r\x00"},
+}
+
+func TestNetASCIIReader(t *testing.T) {
+	for _, test := range netasciiTests {
+		// read a byte at a time to exercise the translation across reads
+		r := NewNetASCIIReader(iotest.OneByteReader(bytes.NewReader([]byte(test.text))))
+
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(actual) != test.netascii {
+			t.Errorf("%q: expected %q, actual %q", test.text, test.netascii, actual)
+		}
+	}
+}
+
+func TestNetASCIIWriter(t *testing.T) {
+	for _, test := range netasciiTests {
+		buf := new(bytes.Buffer)
+		w := NewNetASCIIWriter(buf)
+
+		// write a byte at a time so a CR and the byte after it are split
+		for i := 0; i < len(test.netascii); i++ {
+			_, err := w.Write([]byte{test.netascii[i]})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != test.text {
+			t.Errorf("%q: expected %q, actual %q", test.netascii, test.text, buf.String())
+		}
+	}
+
+	// a CR followed by anything else is passed through
+	buf := new(bytes.Buffer)
+	w := NewNetASCIIWriter(buf)
+	_, _ = w.Write([]byte("a\rb\r"))
+	_ = w.Flush()
+
+	if buf.String() != "a\rb\r" {
+		t.Errorf("expected %q, actual %q", "a\rb\r", buf.String())
+	}
+}
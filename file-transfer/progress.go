@@ -0,0 +1,62 @@
+package filetransfer
+
+import (
+	"net"
+	"sync"
+)
+
+// TransferInfo describes a transfer in progress or just finished.
+type TransferInfo struct {
+	Remote   net.Addr // the peer's address
+	Filename string
+	Mode     string
+	Options  map[string]string // the options the client requested
+	Write    bool              // whether the file is written to the server rather than read from it
+	Stats    Stats             // the statistics so far
+}
+
+// Hooks are called as transfers progress. Any of them may be nil. They are
+// called from the goroutine running the transfer, so they should return
+// quickly.
+type Hooks struct {
+	Start      func(info TransferInfo)            // before the first packet of the transfer
+	Progress   func(info TransferInfo)            // as blocks are acknowledged by or received from the peer
+	Retransmit func(info TransferInfo)            // after packets were sent again
+	Done       func(info TransferInfo, err error) // once the transfer succeeded or failed
+}
+
+// registry keeps track of the transfers in progress.
+type registry struct {
+	mu        sync.Mutex
+	transfers map[*transfer]struct{}
+}
+
+func (r *registry) add(t *transfer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.transfers == nil {
+		r.transfers = make(map[*transfer]struct{})
+	}
+	r.transfers[t] = struct{}{}
+}
+
+func (r *registry) remove(t *transfer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.transfers, t)
+}
+
+// list describes the transfers in progress.
+func (r *registry) list() []TransferInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := make([]TransferInfo, 0, len(r.transfers))
+	for t := range r.transfers {
+		infos = append(infos, t.snapshot())
+	}
+
+	return infos
+}
@@ -0,0 +1,169 @@
+package filetransfer
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yourfavoritedev/go_networking/lossy"
+)
+
+// recorder counts the hook calls of a transfer.
+type recorder struct {
+	mu          sync.Mutex
+	starts      int
+	progress    int
+	retransmits int
+	done        []TransferInfo
+	errs        []error
+}
+
+func (r *recorder) hooks() Hooks {
+	return Hooks{
+		Start: func(TransferInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.starts++
+		},
+		Progress: func(TransferInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.progress++
+		},
+		Retransmit: func(TransferInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.retransmits++
+		},
+		Done: func(info TransferInfo, err error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.done = append(r.done, info)
+			r.errs = append(r.errs, err)
+		},
+	}
+}
+
+func TestHooks(t *testing.T) {
+	payload := make([]byte, 20*BlockSize+1)
+
+	// the server drops packets so some are sent again
+	listen, _ := lossyListener(lossy.Config{Drop: 0.1})
+
+	var server, client recorder
+	s := &Server{Payload: payload, Timeout: time.Second, Hooks: server.hooks(), ListenPacket: listen}
+	addr := serve(t, s)
+
+	c := Client{Timeout: time.Second, WindowSize: 4, Hooks: client.hooks()}
+	stats, err := c.Get(addr, "test", new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server's Done hook may run just after the client returns
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		server.mu.Lock()
+		n := len(server.done)
+		server.mu.Unlock()
+
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, r := range []*recorder{&server, &client} {
+		r.mu.Lock()
+
+		if r.starts != 1 || len(r.done) != 1 {
+			t.Fatalf("expected 1 start and 1 done; actual %d and %d", r.starts, len(r.done))
+		}
+		if r.errs[0] != nil {
+			t.Errorf("unexpected error: %v", r.errs[0])
+		}
+
+		info := r.done[0]
+		if info.Filename != "test" || info.Stats.Bytes != int64(len(payload)) {
+			t.Errorf("expected %d bytes of test; actual %d bytes of %q",
+				len(payload), info.Stats.Bytes, info.Filename)
+		}
+		if info.Stats.Duration <= 0 || info.Stats.Throughput() <= 0 {
+			t.Errorf("expected duration and throughput; actual %s, %f",
+				info.Stats.Duration, info.Stats.Throughput())
+		}
+		if r.progress == 0 {
+			t.Error("expected progress")
+		}
+		if r.retransmits == 0 && info.Stats.Retransmits > 0 {
+			t.Errorf("expected retransmit hook for %d retransmits", info.Stats.Retransmits)
+		}
+
+		r.mu.Unlock()
+	}
+
+	// the client's blocks are received one at a time
+	if client.progress != 21 {
+		t.Errorf("expected progress per block; actual %d", client.progress)
+	}
+
+	server.mu.Lock()
+	if server.retransmits == 0 {
+		t.Error("expected retransmissions")
+	}
+	server.mu.Unlock()
+
+	if stats.Bytes != client.done[0].Stats.Bytes {
+		t.Errorf("expected the returned stats; actual %d bytes", client.done[0].Stats.Bytes)
+	}
+}
+
+func TestServerTransfers(t *testing.T) {
+	release := make(chan struct{})
+
+	s := &Server{
+		Handler: HandlerFunc(func(w io.Writer, _ *ReadReq, _ net.Addr) {
+			_, _ = w.Write([]byte("started"))
+			<-release
+		}),
+		Timeout: time.Second,
+	}
+	addr := serve(t, s)
+
+	done := make(chan error)
+	go func() {
+		_, err := Client{Timeout: time.Second}.Get(addr, "slow.img", new(bytes.Buffer))
+		done <- err
+	}()
+
+	// wait for the transfer to be registered
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.Transfers()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the transfer to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	active := s.Transfers()
+	if len(active) != 1 || active[0].Filename != "slow.img" || active[0].Write {
+		t.Fatalf("expected the download of slow.img; actual %+v", active)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the transfer is gone once the server sees the final ACK
+	deadline = time.Now().Add(5 * time.Second)
+	for len(s.Transfers()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no transfers; actual %+v", s.Transfers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
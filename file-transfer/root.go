@@ -0,0 +1,275 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// DirFS returns a file system for the files in dir. Unlike os.DirFS, it
+// refuses to open files through symbolic links that lead outside dir.
+func DirFS(dir string) fs.FS {
+	return dirFS(dir)
+}
+
+type dirFS string
+
+func (dir dirFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	root, err := filepath.EvalSymlinks(string(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve every symbolic link along the path, then make sure the
+	// result is still inside the root
+	full, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+
+	if !within(root, full) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+
+	return os.Open(full)
+}
+
+// within reports whether the resolved path full is inside root.
+func within(root, full string) bool {
+	rel, err := filepath.Rel(root, full)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// FileServer returns a handler that serves read requests with the contents
+// of the files in root.
+func FileServer(root fs.FS) Handler {
+	return fileHandler{root}
+}
+
+type fileHandler struct {
+	root fs.FS
+}
+
+func (h fileHandler) ServeTFTP(w io.Writer, req *ReadReq, _ net.Addr) {
+	f, err := openFile(h.root, req.Filename)
+	if err != nil {
+		e := errPacket(err)
+		Error(w, e.Code, e.Message)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, ok := req.Options[OptSHA256]; ok {
+		// the digest takes a pass over the file before sending it
+		if err = digest(w, f); err != nil {
+			e := errPacket(err)
+			Error(w, e.Code, e.Message)
+			return
+		}
+	}
+
+	var r io.Reader = f
+	if offset, ok := requestedOffset(req); ok {
+		if r, err = seek(f, offset); err != nil {
+			e := errPacket(err)
+			Error(w, e.Code, e.Message)
+			return
+		}
+		SetOffset(w, offset)
+	}
+
+	_, _ = io.Copy(w, r)
+}
+
+// seek returns a reader for the rest of f from offset on.
+func seek(f fs.File, offset int64) (io.Reader, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > info.Size() {
+		return nil, Err{Code: ErrBadOption, Message: "offset beyond end of file"}
+	}
+
+	switch s := f.(type) {
+	case io.Seeker:
+		if _, err = s.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return f, nil
+	case io.ReaderAt:
+		return io.NewSectionReader(s, offset, info.Size()-offset), nil
+	}
+
+	return nil, errors.New("file does not support seeking")
+}
+
+// digest sets the SHA-256 digest of f for the response w and rewinds f.
+func digest(w io.Writer, f fs.File) error {
+	s, ok := f.(io.Seeker)
+	if !ok {
+		return errors.New("file does not support seeking")
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if _, err := s.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	SetDigest(w, h.Sum(nil))
+
+	return nil
+}
+
+// DirWriter returns a write handler that stores uploaded files in dir.
+// Files are written under a temporary name and only appear once the upload
+// completes. Existing files are never overwritten, and uploads through
+// symbolic links leading outside dir are refused.
+func DirWriter(dir string) WriteHandler {
+	return dirWriter(dir)
+}
+
+type dirWriter string
+
+func (dir dirWriter) CreateTFTP(req *WriteReq, _ net.Addr) (io.WriteCloser, error) {
+	name, err := cleanPath(req.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := filepath.EvalSymlinks(string(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	// the directory must exist already and resolve to a path inside the
+	// root
+	full := filepath.Join(root, filepath.FromSlash(name))
+	parent, err := filepath.EvalSymlinks(filepath.Dir(full))
+	if err != nil {
+		return nil, err
+	}
+
+	if !within(root, parent) {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrPermission}
+	}
+
+	target := filepath.Join(parent, filepath.Base(full))
+	if _, err = os.Lstat(target); err == nil {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+
+	f, err := os.CreateTemp(parent, ".tftp-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &upload{File: f, target: target}, nil
+}
+
+// upload is a file being written to a temporary file next to its target.
+type upload struct {
+	*os.File
+	target string
+}
+
+// Close moves the upload into place, unless a file of the same name
+// appeared in the meantime.
+func (u *upload) Close() error {
+	defer func() { _ = os.Remove(u.Name()) }()
+
+	if err := u.File.Close(); err != nil {
+		return err
+	}
+
+	// unlike a rename, a link fails if the target exists
+	return os.Link(u.Name(), u.target)
+}
+
+// Abort discards the upload.
+func (u *upload) Abort() error {
+	_ = u.File.Close()
+
+	return os.Remove(u.Name())
+}
+
+// openFile opens the regular file filename in root for reading.
+func openFile(root fs.FS, filename string) (fs.File, error) {
+	name, err := cleanPath(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// cleanPath normalizes a requested filename to a path relative to the
+// server's root. Backslashes are treated as separators. Absolute paths and
+// paths that climb out of the root are refused.
+func cleanPath(filename string) (string, error) {
+	name := strings.ReplaceAll(filename, `\`, "/")
+
+	// reject /abs/path as well as drive letters such as C:
+	if path.IsAbs(name) || len(name) >= 2 && name[1] == ':' {
+		return "", &fs.PathError{Op: "open", Path: filename, Err: fs.ErrPermission}
+	}
+
+	name = path.Clean(name)
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "open", Path: filename, Err: fs.ErrPermission}
+	}
+
+	return name, nil
+}
+
+// errPacket returns the ERROR packet sent to the client for err. Details of
+// err stay on the server.
+func errPacket(err error) Err {
+	var e Err
+
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, fs.ErrNotExist):
+		return Err{Code: ErrNotFound, Message: "file not found"}
+	case errors.Is(err, fs.ErrExist):
+		return Err{Code: ErrFileExists, Message: "file already exists"}
+	case errors.Is(err, syscall.ENOSPC):
+		return Err{Code: ErrDiskFull, Message: "disk full"}
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, fs.ErrInvalid):
+		return Err{Code: ErrAccessViolation, Message: "access violation"}
+	}
+
+	return Err{Code: ErrUnknown, Message: "internal error"}
+}
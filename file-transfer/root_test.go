@@ -0,0 +1,88 @@
+package filetransfer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	files := map[string]string{
+		filepath.Join(root, "boot.img"):          "boot image",
+		filepath.Join(root, "pxelinux.cfg", "a"): "config a",
+		filepath.Join(outside, "secret"):         "secret",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		filepath.Join(root, "escape"): filepath.Join(outside, "secret"),
+		filepath.Join(root, "linked"): filepath.Join(root, "pxelinux.cfg", "a"),
+		filepath.Join(root, "up"):     outside,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, name); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	addr := serve(t, &Server{Root: DirFS(root), Timeout: time.Second})
+	c := Client{Timeout: time.Second}
+
+	tests := []struct {
+		filename string
+		content  string
+		code     ErrCode
+	}{
+		{filename: "boot.img", content: "boot image"},
+		{filename: "pxelinux.cfg/a", content: "config a"},
+		{filename: `pxelinux.cfg\a`, content: "config a"},
+		{filename: "pxelinux.cfg/../boot.img", content: "boot image"},
+		{filename: "linked", content: "config a"}, // symlink within the root
+		{filename: "missing", code: ErrNotFound},
+		{filename: "../secret", code: ErrAccessViolation},
+		{filename: "pxelinux.cfg/../../secret", code: ErrAccessViolation},
+		{filename: `..\secret`, code: ErrAccessViolation},
+		{filename: "/etc/passwd", code: ErrAccessViolation},
+		{filename: `C:\boot.ini`, code: ErrAccessViolation},
+		{filename: "escape", code: ErrAccessViolation},
+		{filename: "up/secret", code: ErrAccessViolation},
+		{filename: "pxelinux.cfg", code: ErrAccessViolation}, // directory
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		_, err := c.Get(addr, test.filename, buf)
+
+		if test.content != "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.filename, err)
+			} else if buf.String() != test.content {
+				t.Errorf("%s: expected %q, actual %q", test.filename, test.content, buf)
+			}
+			continue
+		}
+
+		var e Err
+		if !errors.As(err, &e) {
+			t.Errorf("%s: expected error packet, actual %v", test.filename, err)
+			continue
+		}
+
+		if e.Code != test.code {
+			t.Errorf("%s: expected error code %d, actual %d", test.filename, test.code, e.Code)
+		}
+	}
+}
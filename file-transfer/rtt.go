@@ -0,0 +1,65 @@
+package filetransfer
+
+import (
+	"time"
+)
+
+const (
+	minTimeout = 200 * time.Millisecond // the floor Linux uses for TCP; keeps scheduling hiccups from causing retransmissions
+	maxTimeout = time.Minute
+)
+
+// rttEstimator computes the retransmission timeout from round-trip time
+// samples the same way TCP does (RFC 6298).
+type rttEstimator struct {
+	srtt   time.Duration // smoothed round-trip time
+	rttvar time.Duration // round-trip time variation
+	rto    time.Duration // retransmission timeout
+	max    time.Duration
+}
+
+// newRTTEstimator returns an estimator that waits initial until it has a
+// round-trip time sample.
+func newRTTEstimator(initial time.Duration) *rttEstimator {
+	e := &rttEstimator{rto: initial, max: maxTimeout}
+	if initial > e.max {
+		e.max = initial
+	}
+
+	return e
+}
+
+// sample updates the estimate with the round-trip time r. Samples must not
+// be taken from retransmitted packets since it is unknown which copy the
+// reply belongs to (Karn's algorithm).
+func (e *rttEstimator) sample(r time.Duration) {
+	if e.srtt == 0 {
+		e.srtt = r
+		e.rttvar = r / 2
+	} else {
+		delta := e.srtt - r
+		if delta < 0 {
+			delta = -delta
+		}
+		e.rttvar = (3*e.rttvar + delta) / 4
+		e.srtt = (7*e.srtt + r) / 8
+	}
+
+	e.setRTO(e.srtt + 4*e.rttvar)
+}
+
+// backoff doubles the retransmission timeout after a timeout.
+func (e *rttEstimator) backoff() {
+	e.setRTO(2 * e.rto)
+}
+
+func (e *rttEstimator) setRTO(rto time.Duration) {
+	switch {
+	case rto < minTimeout:
+		rto = minTimeout
+	case rto > e.max:
+		rto = e.max
+	}
+
+	e.rto = rto
+}
@@ -0,0 +1,71 @@
+package filetransfer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	e := newRTTEstimator(6 * time.Second)
+	if e.rto != 6*time.Second {
+		t.Fatalf("expected initial RTO 6s; actual %s", e.rto)
+	}
+
+	// the first sample sets SRTT and half of it as the variation
+	e.sample(time.Second)
+	if e.srtt != time.Second || e.rttvar != 500*time.Millisecond {
+		t.Fatalf("unexpected estimate after first sample: srtt %s, rttvar %s", e.srtt, e.rttvar)
+	}
+	if e.rto != 3*time.Second {
+		t.Fatalf("expected RTO 3s; actual %s", e.rto)
+	}
+
+	// steady samples converge on the round-trip time
+	for i := 0; i < 50; i++ {
+		e.sample(time.Second)
+	}
+	if e.srtt != time.Second || e.rto > 1100*time.Millisecond {
+		t.Fatalf("expected convergence on 1s: srtt %s, rto %s", e.srtt, e.rto)
+	}
+
+	// timeouts double the RTO up to the maximum
+	rto := e.rto
+	e.backoff()
+	if e.rto != 2*rto {
+		t.Fatalf("expected RTO %s after backoff; actual %s", 2*rto, e.rto)
+	}
+	for i := 0; i < 20; i++ {
+		e.backoff()
+	}
+	if e.rto != maxTimeout {
+		t.Fatalf("expected RTO capped at %s; actual %s", maxTimeout, e.rto)
+	}
+
+	// tiny samples do not push the RTO below the minimum
+	e = newRTTEstimator(time.Second)
+	e.sample(time.Microsecond)
+	if e.rto != minTimeout {
+		t.Fatalf("expected RTO %s; actual %s", minTimeout, e.rto)
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	payload := make([]byte, 50*BlockSize)
+	addr := serve(t, &Server{Payload: payload, Timeout: time.Second})
+
+	c := Client{Timeout: 2 * time.Second, WindowSize: 4}
+	stats, err := c.Get(addr, "test", new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Bytes != int64(len(payload)) {
+		t.Errorf("expected %d bytes; actual %d", len(payload), stats.Bytes)
+	}
+
+	// the loopback round trip is far shorter than the initial timeout
+	if stats.SRTT <= 0 || stats.RTO >= c.Timeout {
+		t.Errorf("expected the timeout to adapt: srtt %s, rto %s", stats.SRTT, stats.RTO)
+	}
+}
@@ -0,0 +1,496 @@
+package filetransfer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultWindowSize = 64
+
+type Server struct {
+	Handler    Handler       // handles read requests; defaults to serving Root or Payload
+	Root       fs.FS         // the files served for read requests if Handler is nil; see DirFS
+	Payload    []byte        // the payload served for all read requests if Handler and Root are nil
+	Write      WriteHandler  // handles write requests; writes are refused if nil
+	Policy     Policy        // which clients may access which files, and how many transfers run at once
+	Hooks      Hooks         // called as transfers progress
+	Audit      AuditSink     // records the outcome of every request, if not nil
+	Retries    uint8         // the number of times to retry a failed transmission
+	Timeout    time.Duration // the initial duration to wait for an acknowledgment; adapts to the round-trip time
+	WindowSize uint16        // the largest window size the server will negotiate
+
+	// ListenPacket creates the socket for each transfer; defaults to net.ListenPacket
+	ListenPacket func(network, address string) (net.PacketConn, error)
+
+	limits limiter
+	active registry
+
+	once    sync.Once
+	initErr error
+}
+
+// ListenAndServe listens on addr and serves requests. An address without a
+// host, such as ":69", gets a separate socket for each of IPv4 and IPv6,
+// unless one of them is unavailable. An IP address restricts the server to
+// its family, even if it is unspecified, such as 0.0.0.0 or [::]. An
+// address without a host needs a port, since each socket would otherwise
+// get a different one.
+func (s *Server) ListenAndServe(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	var networks []string
+	switch ip := net.ParseIP(host); {
+	case host == "":
+		networks = []string{"udp4", "udp6"}
+	case ip == nil:
+		networks = []string{"udp"} // a host name
+	case ip.To4() != nil:
+		networks = []string{"udp4"}
+	default:
+		networks = []string{"udp6"}
+	}
+
+	if len(networks) > 1 && (port == "" || port == "0") {
+		return fmt.Errorf("a port is required to listen on %s", addr)
+	}
+
+	var conns []net.PacketConn
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	for _, network := range networks {
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			if len(networks) > 1 {
+				log.Printf("Not listening on %s: %v", network, err)
+				continue
+			}
+			return err
+		}
+		conns = append(conns, conn)
+
+		log.Printf("Listening on %s ...\n", conn.LocalAddr())
+	}
+
+	if len(conns) == 0 {
+		return fmt.Errorf("no address family available for %s", addr)
+	}
+
+	// serve until any of the sockets fails
+	errs := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn net.PacketConn) { errs <- s.Serve(conn) }(conn)
+	}
+
+	return <-errs
+}
+
+// Serve serves requests arriving on conn. It may be called for several
+// sockets at once, such as one per address family.
+func (s *Server) Serve(conn net.PacketConn) error {
+	if conn == nil {
+		return errors.New("nil connection")
+	}
+
+	s.once.Do(s.init)
+	if s.initErr != nil {
+		return s.initErr
+	}
+
+	buf := make([]byte, DatagramSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		var (
+			rrq    ReadReq
+			wrq    WriteReq
+			info   TransferInfo
+			perm   Permission
+			handle func()
+		)
+
+		var op OpCode
+		if n >= 2 {
+			op = OpCode(binary.BigEndian.Uint16(buf[:2]))
+		}
+
+		switch op {
+		case OpRRQ:
+			if err = rrq.UnmarshalBinary(buf[:n]); err != nil {
+				s.reject(conn, addr, err)
+				continue
+			}
+			info = TransferInfo{Remote: addr, Filename: rrq.Filename, Mode: rrq.Mode, Options: rrq.Options}
+			perm = PermRead
+			handle = func() { s.handle(conn.LocalAddr(), addr, rrq) }
+		case OpWRQ:
+			if err = wrq.UnmarshalBinary(buf[:n]); err != nil {
+				s.reject(conn, addr, err)
+				continue
+			}
+			info = TransferInfo{Remote: addr, Filename: wrq.Filename, Mode: wrq.Mode, Options: wrq.Options, Write: true}
+			perm = PermWrite
+			handle = func() { s.handleWrite(conn.LocalAddr(), addr, wrq) }
+		default:
+			// anything else isn't a request, such as a stray packet of an
+			// old transfer, so it's discarded rather than answered
+			log.Printf("[%s] bad request", addr)
+			continue
+		}
+
+		ip := addrIP(addr)
+
+		e := s.Policy.authorize(ip, info.Filename, perm)
+		if e == nil && perm == PermWrite && s.Write == nil {
+			e = &Err{Code: ErrAccessViolation, Message: "writes not allowed"}
+		}
+		if e == nil && !s.limits.acquire(ip, s.Policy.MaxTransfers, s.Policy.MaxTransfersPerIP) {
+			e = &Err{Code: ErrAccessViolation, Message: "too many transfers"}
+		}
+		if e != nil {
+			// refuse from the listening socket, since there is no
+			// transfer to give an ID to
+			log.Printf("[%s] refused %s: %s", addr, info.Filename, e.Message)
+			if pkt, err := e.MarshalBinary(); err == nil {
+				_, _ = conn.WriteTo(pkt, addr)
+			}
+			s.audit(info, OutcomeRefused, *e)
+			continue
+		}
+
+		go func() {
+			defer s.limits.release(ip)
+			handle()
+		}()
+	}
+}
+
+// reject replies to a malformed RRQ or WRQ from the listening socket, so the
+// client stops retransmitting it.
+func (s *Server) reject(conn net.PacketConn, addr net.Addr, err error) {
+	log.Printf("[%s] bad request: %v", addr, err)
+
+	e := Err{Code: ErrIllegalOp, Message: err.Error()}
+	if pkt, err := e.MarshalBinary(); err == nil {
+		_, _ = conn.WriteTo(pkt, addr)
+	}
+}
+
+// init applies the defaults to s once.
+func (s *Server) init() {
+	if s.Handler == nil {
+		switch {
+		case s.Root != nil:
+			s.Handler = FileServer(s.Root)
+		case s.Payload != nil:
+			s.Handler = payloadHandler(s.Payload)
+		default:
+			s.initErr = errors.New("handler, root or payload is required")
+			return
+		}
+	}
+
+	if s.Retries == 0 {
+		s.Retries = 10
+	}
+
+	if s.Timeout == 0 {
+		s.Timeout = 6 * time.Second
+	}
+
+	if s.WindowSize == 0 {
+		s.WindowSize = defaultWindowSize
+	}
+}
+
+// addrIP returns the IP address of addr, or nil if it has none.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
+
+// listen returns a new socket for a transfer with the client at
+// clientAddr. The socket's port is the server's transfer ID. The socket is
+// of the client's address family and on the host the client sent its
+// request to, so replies come from the address the client expects.
+func (s *Server) listen(serverAddr, clientAddr net.Addr) (net.PacketConn, error) {
+	network := "udp6"
+	if ip := addrIP(clientAddr); ip.To4() != nil {
+		network = "udp4"
+	}
+
+	// a socket listening on all addresses replies from any of them
+	host := ""
+	if ip := addrIP(serverAddr); ip != nil && !ip.IsUnspecified() {
+		host = ip.String()
+	}
+
+	listen := net.ListenPacket
+	if s.ListenPacket != nil {
+		listen = s.ListenPacket
+	}
+
+	return listen(network, net.JoinHostPort(host, "0"))
+}
+
+// handle serves rrq from a new socket.
+func (s *Server) handle(serverAddr, clientAddr net.Addr, rrq ReadReq) {
+	log.Printf("[%s] requested file: %s", clientAddr, rrq.Filename)
+
+	info := TransferInfo{Remote: clientAddr, Filename: rrq.Filename, Mode: rrq.Mode, Options: rrq.Options}
+
+	conn, err := s.listen(serverAddr, clientAddr)
+	if err != nil {
+		log.Printf("[%s] listen: %v", clientAddr, err)
+		s.audit(info, OutcomeFailed, errPacket(err))
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	t := newTransfer(conn, clientAddr, s.Retries, s.Timeout)
+	done := s.track(t, info)
+	defer func() { done(err) }()
+
+	// the handler writes into a pipe that feeds the DATA packets
+	pr, pw := io.Pipe()
+	defer func() { _ = pr.Close() }()
+
+	rw := &responseWriter{pw: pw}
+
+	go func() {
+		s.Handler.ServeTFTP(rw, &rrq, clientAddr)
+		_ = pw.Close()
+	}()
+
+	// wait for the handler to produce something, finish or fail before
+	// acknowledging any options, so a failure is the only reply
+	r := bufio.NewReaderSize(pr, BlockSize)
+	if _, err = r.Peek(1); err != nil && err != io.EOF {
+		log.Printf("[%s] %v", clientAddr, err)
+		_ = t.fail(errPacket(err))
+		return
+	}
+
+	oack := s.negotiate(rrq.Options, t)
+
+	// the handler's options are only known now
+	for _, name := range []string{OptSHA256, OptOffset} {
+		if _, ok := rrq.Options[name]; ok {
+			if v, ok := rw.option(name); ok {
+				oack[name] = v
+			}
+		}
+	}
+
+	if len(oack) > 0 {
+		if err = t.oack(oack); err != nil {
+			log.Printf("[%s] negotiating options: %v", clientAddr, err)
+			return
+		}
+	}
+
+	var src io.Reader = r
+	if strings.ToLower(rrq.Mode) == ModeNetASCII {
+		src = NewNetASCIIReader(src)
+	}
+
+	if err = t.send(src); err != nil {
+		log.Printf("[%s] %v", clientAddr, err)
+		return
+	}
+
+	stats := t.Stats()
+	log.Printf("[%s] sent %s: %d bytes in %s (%.0f B/s), %d retransmits, srtt %s, rto %s",
+		clientAddr, rrq.Filename, stats.Bytes, stats.Duration, stats.Throughput(),
+		stats.Retransmits, stats.SRTT, stats.RTO)
+}
+
+// handleWrite receives the file of wrq on a new socket.
+func (s *Server) handleWrite(serverAddr, clientAddr net.Addr, wrq WriteReq) {
+	log.Printf("[%s] uploading file: %s", clientAddr, wrq.Filename)
+
+	info := TransferInfo{Remote: clientAddr, Filename: wrq.Filename, Mode: wrq.Mode,
+		Options: wrq.Options, Write: true}
+
+	conn, err := s.listen(serverAddr, clientAddr)
+	if err != nil {
+		log.Printf("[%s] listen: %v", clientAddr, err)
+		s.audit(info, OutcomeFailed, errPacket(err))
+		return
+	}
+
+	t := newTransfer(conn, clientAddr, s.Retries, s.Timeout)
+	defer t.close()
+	done := s.track(t, info)
+	defer func() { done(err) }()
+
+	wc, err := s.Write.CreateTFTP(&wrq, clientAddr)
+	if err != nil {
+		log.Printf("[%s] %v", clientAddr, err)
+		_ = t.fail(errPacket(err))
+		return
+	}
+
+	var (
+		dst      io.Writer = wc
+		netascii *NetASCIIWriter
+	)
+	if strings.ToLower(wrq.Mode) == ModeNetASCII {
+		netascii = NewNetASCIIWriter(wc)
+		dst = netascii
+	}
+
+	// the OACK, if any, takes the place of ACK 0 and is sent again until
+	// the first DATA packet arrives
+	var pkt []byte
+	if oack := s.negotiate(wrq.Options, t); len(oack) > 0 {
+		pkt, err = oack.MarshalBinary()
+		t.greeting = pkt
+	} else {
+		pkt, err = Ack(0).MarshalBinary()
+	}
+	if err == nil {
+		err = t.write(pkt)
+	}
+	if err == nil {
+		err = t.receive(dst, nil, time.Now())
+	}
+	if err == nil && netascii != nil {
+		err = netascii.Flush()
+	}
+	if err != nil {
+		log.Printf("[%s] %v", clientAddr, err)
+		if a, ok := wc.(interface{ Abort() error }); ok {
+			_ = a.Abort()
+		} else {
+			_ = wc.Close()
+		}
+		return
+	}
+
+	if err = wc.Close(); err != nil {
+		log.Printf("[%s] storing %s: %v", clientAddr, wrq.Filename, err)
+		return
+	}
+
+	stats := t.Stats()
+	log.Printf("[%s] received %s: %d bytes in %s (%.0f B/s), %d retransmits, srtt %s, rto %s",
+		clientAddr, wrq.Filename, stats.Bytes, stats.Duration, stats.Throughput(),
+		stats.Retransmits, stats.SRTT, stats.RTO)
+}
+
+// track reports the start of t to the hooks and lists it among the active
+// transfers. The returned function reports its outcome, including to the
+// audit sink.
+func (s *Server) track(t *transfer, info TransferInfo) func(err error) {
+	t.hooks = s.Hooks
+	t.info = info
+
+	s.active.add(t)
+	t.begin()
+
+	return func(err error) {
+		s.active.remove(t)
+		t.end(err)
+
+		if err != nil {
+			s.audit(t.Info(), OutcomeFailed, errPacket(err))
+		} else {
+			s.audit(t.Info(), OutcomeOK, Err{})
+		}
+	}
+}
+
+// audit records the outcome of a request if the server has an audit sink.
+// e describes the error of a refused or failed request.
+func (s *Server) audit(info TransferInfo, outcome string, e Err) {
+	if s.Audit == nil {
+		return
+	}
+
+	rec := AuditRecord{
+		Time:     time.Now(),
+		Remote:   info.Remote.String(),
+		Write:    info.Write,
+		Filename: info.Filename,
+		Mode:     info.Mode,
+		Options:  info.Options,
+		Outcome:  outcome,
+		Code:     e.Code,
+		Error:    e.Message,
+		Bytes:    info.Stats.Bytes,
+		Duration: info.Stats.Duration,
+	}
+
+	if err := s.Audit.Audit(rec); err != nil {
+		log.Printf("[%s] audit: %v", info.Remote, err)
+	}
+}
+
+// Transfers describes the transfers in progress.
+func (s *Server) Transfers() []TransferInfo {
+	return s.active.list()
+}
+
+// negotiate applies the requested options the server supports to t and
+// returns them for the OACK. Unsupported or invalid options are left out.
+func (s *Server) negotiate(options map[string]string, t *transfer) OAck {
+	oack := make(OAck)
+
+	if v, ok := options[OptRollover]; ok {
+		if rollover, err := parseRollover(v); err == nil {
+			t.rollover = rollover
+			oack[OptRollover] = v
+		}
+	}
+
+	if v, ok := options[OptWindowSize]; ok {
+		if size, err := parseWindowSize(v); err == nil {
+			// the server may settle on a smaller window than requested
+			if size > s.WindowSize {
+				size = s.WindowSize
+			}
+			// block numbers repeat every 65535 blocks when rolling over
+			// to 1, so a full window would make the ACK ambiguous
+			if t.rollover == 1 && size == math.MaxUint16 {
+				size--
+			}
+			t.windowSize = size
+			oack[OptWindowSize] = strconv.Itoa(int(size))
+		}
+	}
+
+	return oack
+}
@@ -0,0 +1,523 @@
+package filetransfer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// serve starts s on a loopback address and returns the address.
+func serve(t *testing.T, s *Server) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() { _ = s.Serve(conn) }()
+
+	return conn.LocalAddr().String()
+}
+
+func TestServerWindowSize(t *testing.T) {
+	payload := make([]byte, 100*BlockSize+123)
+	_, err := rand.Read(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serve(t, &Server{Payload: payload, Timeout: time.Second})
+
+	for _, size := range []uint16{0, 1, 4, 16, 64} {
+		t.Run(fmt.Sprintf("window %d", size), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			c := Client{Timeout: time.Second, WindowSize: size}
+
+			stats, err := c.Get(addr, "test", buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stats.Bytes != int64(len(payload)) || !bytes.Equal(payload, buf.Bytes()) {
+				t.Fatalf("received %d bytes; expected %d", stats.Bytes, len(payload))
+			}
+		})
+	}
+}
+
+func TestServerWindowRollback(t *testing.T) {
+	payload := make([]byte, 10*BlockSize)
+	addr := serve(t, &Server{Payload: payload, Timeout: 500 * time.Millisecond})
+
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	rrq, err := ReadReq{
+		Filename: "test",
+		Options:  map[string]string{OptWindowSize: "4"},
+	}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.WriteTo(rrq, serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, DatagramSize)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var oack OAck
+	n, peer, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = oack.UnmarshalBinary(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if oack[OptWindowSize] != "4" {
+		t.Fatalf("expected window size 4; actual %q", oack[OptWindowSize])
+	}
+
+	// readBlocks reads the next count DATA packets starting at block first
+	// and returns their block numbers. The server's timeout adapts to the
+	// loopback round-trip time, so it may retransmit earlier blocks while
+	// the test is busy; those are skipped.
+	readBlocks := func(first uint16, count int) []uint16 {
+		var blocks []uint16
+		for len(blocks) < count {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var data Data
+			if err = data.UnmarshalBinary(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if data.Block >= first {
+				blocks = append(blocks, data.Block)
+			}
+		}
+		return blocks
+	}
+
+	ack := func(block uint16) {
+		b, _ := Ack(block).MarshalBinary()
+		if _, err := conn.WriteTo(b, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ack(0)
+	if blocks := readBlocks(1, 4); fmt.Sprint(blocks) != "[1 2 3 4]" {
+		t.Fatalf("expected first window [1 2 3 4]; actual %v", blocks)
+	}
+
+	// pretend block 3 was lost; the server must resend from block 3
+	ack(2)
+	if blocks := readBlocks(3, 4); fmt.Sprint(blocks) != "[3 4 5 6]" {
+		t.Fatalf("expected window [3 4 5 6] after rollback; actual %v", blocks)
+	}
+
+	// an ACK for the end of the window moves on to the next window
+	ack(6)
+	if blocks := readBlocks(7, 4); fmt.Sprint(blocks) != "[7 8 9 10]" {
+		t.Fatalf("expected window [7 8 9 10]; actual %v", blocks)
+	}
+
+	ack(10)
+	// the payload is a multiple of the block size, so it ends with an empty block
+	if blocks := readBlocks(11, 1); fmt.Sprint(blocks) != "[11]" {
+		t.Fatalf("expected final block [11]; actual %v", blocks)
+	}
+	ack(11)
+}
+
+func TestServerRollover(t *testing.T) {
+	// more blocks than fit in a 16-bit block number
+	payload := make([]byte, (math.MaxUint16+100)*BlockSize+1)
+	_, err := rand.Read(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serve(t, &Server{Payload: payload, Timeout: time.Second})
+
+	for _, rollover := range []uint16{0, 1} {
+		t.Run(fmt.Sprintf("rollover %d", rollover), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			c := Client{Timeout: time.Second, WindowSize: 64, Rollover: rollover}
+
+			stats, err := c.Get(addr, "test", buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stats.Bytes != int64(len(payload)) || !bytes.Equal(payload, buf.Bytes()) {
+				t.Fatalf("received %d bytes; expected %d", stats.Bytes, len(payload))
+			}
+		})
+	}
+}
+
+func TestServerNetASCII(t *testing.T) {
+	text := bytes.Repeat([]byte("line\r\nwith a bare\r and a newline\n"), 100)
+	addr := serve(t, &Server{Payload: text, Timeout: time.Second})
+
+	buf := new(bytes.Buffer)
+	c := Client{Timeout: time.Second, Mode: ModeNetASCII}
+
+	stats, err := c.Get(addr, "test.txt", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(text, buf.Bytes()) {
+		t.Fatalf("expected %q, actual %q", text, buf.Bytes())
+	}
+
+	// every CR and LF take up two bytes on the wire
+	expected := len(text) + bytes.Count(text, []byte("\r")) + bytes.Count(text, []byte("\n"))
+	if stats.Bytes != int64(expected) {
+		t.Errorf("expected %d bytes on the wire, actual %d", expected, stats.Bytes)
+	}
+}
+
+// request sends a lock-step read request to the server at addr from conn
+// and returns the first DATA packet along with the server's transfer ID.
+func request(t *testing.T, conn net.PacketConn, addr string) (Data, net.Addr) {
+	t.Helper()
+
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rrq, err := ReadReq{Filename: "test"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.WriteTo(rrq, serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return readData(t, conn)
+}
+
+// readData reads the next DATA packet from conn.
+func readData(t *testing.T, conn net.PacketConn) (Data, net.Addr) {
+	t.Helper()
+
+	buf := make([]byte, DatagramSize)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	n, peer, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data Data
+	if err = data.UnmarshalBinary(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+
+	return data, peer
+}
+
+func TestServerDuplicateAck(t *testing.T) {
+	addr := serve(t, &Server{Payload: make([]byte, 2*BlockSize+1), Timeout: time.Second})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	data, peer := request(t, conn, addr)
+	if data.Block != 1 {
+		t.Fatalf("expected block 1; actual %d", data.Block)
+	}
+
+	// acknowledge block 1 twice, as if the ACK had been retransmitted
+	ack, _ := Ack(1).MarshalBinary()
+	for i := 0; i < 2; i++ {
+		if _, err = conn.WriteTo(ack, peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, _ = readData(t, conn); data.Block != 2 {
+		t.Fatalf("expected block 2; actual %d", data.Block)
+	}
+
+	// the duplicate ACK must not trigger another copy of block 2 before the
+	// retransmission timeout, which is at least minTimeout
+	_ = conn.SetReadDeadline(time.Now().Add(minTimeout / 2))
+	if _, _, err = conn.ReadFrom(make([]byte, DatagramSize)); err == nil {
+		t.Fatal("server answered a duplicate ACK")
+	}
+
+	ack, _ = Ack(2).MarshalBinary()
+	if _, err = conn.WriteTo(ack, peer); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ = readData(t, conn); data.Block != 3 {
+		t.Fatalf("expected block 3; actual %d", data.Block)
+	}
+
+	ack, _ = Ack(3).MarshalBinary()
+	_, _ = conn.WriteTo(ack, peer)
+}
+
+func TestServerUnknownID(t *testing.T) {
+	addr := serve(t, &Server{Payload: make([]byte, BlockSize+1), Timeout: time.Second})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, peer := request(t, conn, addr)
+
+	// an interloper writes to the transfer's port
+	interloper, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer interloper.Close()
+
+	ack, _ := Ack(1).MarshalBinary()
+	if _, err = interloper.WriteTo(ack, peer); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, DatagramSize)
+	_ = interloper.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := interloper.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e Err
+	if err = e.UnmarshalBinary(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != ErrUnknownID {
+		t.Fatalf("expected error code %d; actual %d", ErrUnknownID, e.Code)
+	}
+
+	// the interloper's ACK did not advance the transfer; the client's does
+	if _, err = conn.WriteTo(ack, peer); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := readData(t, conn)
+	if data.Block != 2 {
+		t.Fatalf("expected block 2; actual %d", data.Block)
+	}
+
+	ack, _ = Ack(2).MarshalBinary()
+	_, _ = conn.WriteTo(ack, peer)
+}
+
+func TestServerDualStack(t *testing.T) {
+	conn4, err := net.ListenPacket("udp4", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn4.Close() })
+
+	conn6, err := net.ListenPacket("udp6", "[::1]:")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { _ = conn6.Close() })
+
+	payload := make([]byte, 3*BlockSize+10)
+	s := &Server{Payload: payload, Write: DirWriter(t.TempDir()), Timeout: time.Second}
+
+	// one server, one listener per family
+	for _, conn := range []net.PacketConn{conn4, conn6} {
+		go func(conn net.PacketConn) { _ = s.Serve(conn) }(conn)
+	}
+
+	for _, listener := range []net.PacketConn{conn4, conn6} {
+		addr := listener.LocalAddr().(*net.UDPAddr)
+
+		// the reply comes from the same address on a new port
+		conn, err := net.ListenPacket("udp", net.JoinHostPort(addr.IP.String(), "0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, peer := request(t, conn, addr.String())
+		_ = conn.Close()
+
+		tid := peer.(*net.UDPAddr)
+		if !tid.IP.Equal(addr.IP) || tid.Port == addr.Port {
+			t.Errorf("%s: expected a new port on the same address; actual %s", addr, tid)
+		}
+
+		c := Client{Timeout: time.Second, WindowSize: 2}
+
+		buf := new(bytes.Buffer)
+		if _, err = c.Get(addr.String(), "test", buf); err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+		if buf.Len() != len(payload) {
+			t.Errorf("%s: expected %d bytes; actual %d", addr, len(payload), buf.Len())
+		}
+
+		filename := fmt.Sprintf("upload%d", addr.Port)
+		if _, err = c.Put(addr.String(), filename, bytes.NewReader(payload)); err != nil {
+			t.Errorf("%s: %v", addr, err)
+		}
+	}
+}
+
+// freePort returns a UDP port that's free on both IPv4 and IPv6 loopback
+// addresses, skipping the test if IPv6 is unavailable.
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	conn6, err := net.ListenPacket("udp6", "[::1]:")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer func() { _ = conn6.Close() }()
+
+	_, port, _ := net.SplitHostPort(conn6.LocalAddr().String())
+
+	conn4, err := net.ListenPacket("udp4", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Skip(err)
+	}
+	_ = conn4.Close()
+
+	return port
+}
+
+// get downloads filename from addr, retrying until the server listens.
+func get(t *testing.T, addr, filename string) []byte {
+	t.Helper()
+
+	c := Client{Retries: 1, Timeout: 100 * time.Millisecond}
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		buf := new(bytes.Buffer)
+		_, err := c.Get(addr, filename, buf)
+		if err == nil {
+			return buf.Bytes()
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: %v", addr, err)
+		}
+	}
+}
+
+func TestServerListenAndServe(t *testing.T) {
+	payload := make([]byte, 3*BlockSize+10)
+	_, err := rand.Read(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server can't be stopped, so it serves until the tests end
+	port := freePort(t)
+	go func() { _ = (&Server{Payload: payload}).ListenAndServe(":" + port) }()
+
+	// one socket per family, both on the same port
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		if actual := get(t, net.JoinHostPort(host, port), "test"); !bytes.Equal(payload, actual) {
+			t.Errorf("%s: expected %d bytes; actual %d", host, len(payload), len(actual))
+		}
+	}
+
+	// each socket would get a different ephemeral port
+	if err = (&Server{Payload: payload}).ListenAndServe(":0"); err == nil {
+		t.Error("expected an error listening on port 0 of both families")
+	}
+
+	// a host name listens on one of its addresses
+	port = freePort(t)
+	go func() { _ = (&Server{Payload: payload}).ListenAndServe(net.JoinHostPort("localhost", port)) }()
+
+	if actual := get(t, net.JoinHostPort("localhost", port), "test"); !bytes.Equal(payload, actual) {
+		t.Errorf("localhost: expected %d bytes; actual %d", len(payload), len(actual))
+	}
+
+	// IPv4 is served even if IPv6 is unavailable
+	port = freePort(t)
+	conn6, err := net.ListenPacket("udp6", net.JoinHostPort("::", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn6.Close() }()
+
+	go func() { _ = (&Server{Payload: payload}).ListenAndServe(":" + port) }()
+
+	if actual := get(t, net.JoinHostPort("127.0.0.1", port), "test"); !bytes.Equal(payload, actual) {
+		t.Errorf("IPv4 alone: expected %d bytes; actual %d", len(payload), len(actual))
+	}
+}
+
+func TestServerBadRequest(t *testing.T) {
+	addr := serve(t, &Server{Payload: make([]byte, BlockSize), Timeout: time.Second})
+
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a mail mode RRQ and a WRQ without a mode
+	for _, pkt := range [][]byte{
+		[]byte("\x00\x01test\x00mail\x00"),
+		[]byte("\x00\x02test\x00"),
+	} {
+		if _, err = conn.WriteTo(pkt, serverAddr); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, DatagramSize)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("%q: %v", pkt, err)
+		}
+
+		var e Err
+		if err = e.UnmarshalBinary(buf[:n]); err != nil {
+			t.Fatalf("%q: %v", pkt, err)
+		}
+		if e.Code != ErrIllegalOp || e.Message == "" || from.String() != addr {
+			t.Errorf("%q: expected an illegal operation error from %s; actual %+v from %s", pkt, addr, e, from)
+		}
+	}
+}
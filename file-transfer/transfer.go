@@ -0,0 +1,603 @@
+package filetransfer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stats describes a transfer.
+type Stats struct {
+	Bytes       int64         // payload bytes sent or received
+	Retransmits int           // packets sent again after a timeout or rollback
+	Duplicates  int           // duplicate ACKs ignored
+	SRTT        time.Duration // smoothed round-trip time
+	RTTVar      time.Duration // round-trip time variation
+	RTO         time.Duration // retransmission timeout
+	Duration    time.Duration // time since the transfer started, or how long it took
+}
+
+// Throughput returns the payload bytes transferred per second.
+func (s Stats) Throughput() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+// transfer holds the state shared by both ends of a single transfer: the
+// local socket, the peer's address (its transfer ID) and the negotiated
+// parameters.
+type transfer struct {
+	conn       net.PacketConn
+	peer       net.Addr
+	retries    uint8
+	rtt        *rttEstimator
+	windowSize uint16
+	rollover   uint16 // the block number following 65535
+	greeting   []byte // sent by receive instead of ACK 0 until the first block arrives
+	final      uint16 // the final block received, if complete
+	complete   bool   // whether receive acknowledged the final block
+	stats      Stats
+
+	hooks    Hooks
+	info     TransferInfo // describes the transfer to hooks, apart from the statistics
+	started  time.Time
+	finished time.Time
+
+	mu     sync.Mutex
+	latest Stats // the statistics as of the last progress, for other goroutines
+}
+
+func newTransfer(conn net.PacketConn, peer net.Addr, retries uint8, timeout time.Duration) *transfer {
+	return &transfer{
+		conn:       conn,
+		peer:       peer,
+		retries:    retries,
+		rtt:        newRTTEstimator(timeout),
+		windowSize: 1,
+		started:    time.Now(),
+	}
+}
+
+// Stats returns the transfer's statistics so far.
+func (t *transfer) Stats() Stats {
+	s := t.stats
+	s.SRTT = t.rtt.srtt
+	s.RTTVar = t.rtt.rttvar
+	s.RTO = t.rtt.rto
+
+	if t.finished.IsZero() {
+		s.Duration = time.Since(t.started)
+	} else {
+		s.Duration = t.finished.Sub(t.started)
+	}
+
+	return s
+}
+
+// Info describes the transfer along with its statistics so far.
+func (t *transfer) Info() TransferInfo {
+	info := t.info
+	info.Stats = t.Stats()
+
+	return info
+}
+
+// snapshot is like Info, but safe to call from other goroutines. Its
+// statistics are those of the last progress.
+func (t *transfer) snapshot() TransferInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info := t.info
+	info.Stats = t.latest
+	if t.finished.IsZero() {
+		info.Stats.Duration = time.Since(t.started)
+	}
+
+	return info
+}
+
+// publish makes the statistics so far available to snapshot and returns
+// the transfer's description for the hooks.
+func (t *transfer) publish() TransferInfo {
+	info := t.Info()
+
+	t.mu.Lock()
+	t.latest = info.Stats
+	t.mu.Unlock()
+
+	return info
+}
+
+// begin reports the start of the transfer.
+func (t *transfer) begin() {
+	info := t.publish()
+	if t.hooks.Start != nil {
+		t.hooks.Start(info)
+	}
+}
+
+// progress reports blocks acknowledged by or received from the peer.
+func (t *transfer) progress() {
+	info := t.publish()
+	if t.hooks.Progress != nil {
+		t.hooks.Progress(info)
+	}
+}
+
+// retransmitted counts n packets sent again and reports them.
+func (t *transfer) retransmitted(n int) {
+	if n == 0 {
+		return
+	}
+	t.stats.Retransmits += n
+
+	info := t.publish()
+	if t.hooks.Retransmit != nil {
+		t.hooks.Retransmit(info)
+	}
+}
+
+// end reports the outcome of the transfer.
+func (t *transfer) end(err error) {
+	t.mu.Lock()
+	t.finished = time.Now()
+	t.mu.Unlock()
+
+	info := t.publish()
+	if t.hooks.Done != nil {
+		t.hooks.Done(info, err)
+	}
+}
+
+func (t *transfer) write(p []byte) error {
+	_, err := t.conn.WriteTo(p, t.peer)
+
+	return err
+}
+
+// read reads the next datagram from the peer into buf. Datagrams from
+// other addresses belong to another transfer; they get an ERROR packet in
+// reply without disturbing this transfer (RFC 1350). The caller is
+// responsible for setting the read deadline.
+func (t *transfer) read(buf []byte) (int, error) {
+	for {
+		n, addr, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+
+		if addr.String() == t.peer.String() {
+			return n, nil
+		}
+
+		// never answer an ERROR packet with another one
+		if n >= 2 && OpCode(binary.BigEndian.Uint16(buf[:2])) == OpErr {
+			continue
+		}
+
+		pkt, err := Err{Code: ErrUnknownID, Message: "unknown transfer ID"}.MarshalBinary()
+		if err == nil {
+			_, _ = t.conn.WriteTo(pkt, addr)
+		}
+	}
+}
+
+func (t *transfer) ack(block uint16) error {
+	pkt, err := Ack(block).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return t.write(pkt)
+}
+
+// request sends req to the peer until it gets a reply and returns the
+// reply. The reply may come from any address, which becomes the peer since
+// a server replies from a new port that identifies the transfer.
+func (t *transfer) request(req []byte) ([]byte, error) {
+	buf := make([]byte, DatagramSize)
+
+RETRY:
+	for i := t.retries; i > 0; i-- {
+		if i < t.retries {
+			t.retransmitted(1)
+		}
+
+		if err := t.write(req); err != nil {
+			return nil, err
+		}
+
+		sent := time.Now()
+		_ = t.conn.SetReadDeadline(sent.Add(t.rtt.rto))
+		n, peer, err := t.conn.ReadFrom(buf)
+		if err != nil {
+			if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+				t.rtt.backoff()
+				continue RETRY
+			}
+
+			return nil, fmt.Errorf("waiting for reply: %w", err)
+		}
+
+		if i == t.retries {
+			t.rtt.sample(time.Since(sent))
+		}
+		t.peer = peer
+
+		return buf[:n], nil
+	}
+
+	return nil, errors.New("exhausted retries")
+}
+
+// fail sends e to the peer to terminate the transfer.
+func (t *transfer) fail(e Err) error {
+	pkt, err := e.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return t.write(pkt)
+}
+
+// oack sends o to the peer until the peer acknowledges block 0, which
+// confirms the negotiated options.
+func (t *transfer) oack(o OAck) error {
+	pkt, err := o.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var (
+		ackPkt Ack
+		errPkt Err
+		buf    = make([]byte, DatagramSize)
+	)
+
+RETRY:
+	for i := t.retries; i > 0; i-- {
+		if i < t.retries {
+			t.retransmitted(1)
+		}
+
+		if err = t.write(pkt); err != nil {
+			return err
+		}
+
+		// wait for the peer's ACK packet; anything else is ignored until
+		// the timeout
+		sent := time.Now()
+		_ = t.conn.SetReadDeadline(sent.Add(t.rtt.rto))
+		for {
+			n, err := t.read(buf)
+			if err != nil {
+				if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+					t.rtt.backoff()
+					continue RETRY
+				}
+
+				return fmt.Errorf("waiting for ACK: %w", err)
+			}
+
+			switch {
+			case ackPkt.UnmarshalBinary(buf[:n]) == nil:
+				if ackPkt == 0 {
+					if i == t.retries {
+						t.rtt.sample(time.Since(sent))
+					}
+					return nil
+				}
+			case errPkt.UnmarshalBinary(buf[:n]) == nil:
+				return errPkt
+			}
+		}
+	}
+
+	return errors.New("exhausted retries")
+}
+
+// send writes r to the peer as a series of DATA packets. Up to windowSize
+// packets are in flight at once. The peer acknowledges the last block it
+// received in order, so everything after that block is sent again with the
+// next window.
+func (t *transfer) send(r io.Reader) error {
+	var (
+		ackPkt  Ack
+		errPkt  Err
+		dataPkt = &Data{Payload: r, Rollover: t.rollover}
+		window  [][]byte // packets sent but not yet acknowledged
+		last    bool     // whether the final packet is in the window
+		buf     = make([]byte, DatagramSize)
+	)
+
+NEXTWINDOW:
+	for !last || len(window) > 0 {
+		resent := len(window) // packets rolled back are sent again
+
+		// top up the window with new packets
+		for !last && len(window) < int(t.windowSize) {
+			data, err := dataPkt.MarshalBinary()
+			if err != nil {
+				// the source failed; let the peer know
+				_ = t.fail(errPacket(err))
+				return err
+			}
+
+			window = append(window, data)
+			last = len(data) < DatagramSize
+			t.stats.Bytes += int64(len(data) - 4)
+		}
+
+	RETRY:
+		for i := t.retries; i > 0; i-- {
+			if i < t.retries {
+				resent = len(window)
+			}
+			t.retransmitted(resent)
+
+			for _, data := range window {
+				if err := t.write(data); err != nil {
+					return err
+				}
+			}
+
+			// wait for the peer's ACK packet
+			sent := time.Now()
+			_ = t.conn.SetReadDeadline(sent.Add(t.rtt.rto))
+			for {
+				n, err := t.read(buf)
+				if err != nil {
+					if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+						t.rtt.backoff()
+						continue RETRY
+					}
+
+					return fmt.Errorf("waiting for ACK: %w", err)
+				}
+
+				switch {
+				case ackPkt.UnmarshalBinary(buf[:n]) == nil:
+					if j := ackIndex(window, ackPkt); j >= 0 {
+						// only time windows without retransmissions (Karn's algorithm)
+						if resent == 0 {
+							t.rtt.sample(time.Since(sent))
+						}
+
+						// slide the window past the acknowledged block
+						window = window[j+1:]
+						t.progress()
+						continue NEXTWINDOW
+					}
+
+					// a duplicate ACK for a block acknowledged before.
+					// Answering it would send the window twice from here
+					// on (Sorcerer's Apprentice Syndrome), so only the
+					// timeout triggers a retransmission. This includes an
+					// ACK for the block before the window, which the peer
+					// sends when the first block of the window is lost.
+					t.stats.Duplicates++
+				case errPkt.UnmarshalBinary(buf[:n]) == nil:
+					return errPkt
+				}
+			}
+		}
+
+		return errors.New("exhausted retries")
+	}
+
+	return nil
+}
+
+// ackIndex returns the index of the packet in window acknowledged by ack,
+// or -1 if ack does not acknowledge any packet in the window.
+func ackIndex(window [][]byte, ack Ack) int {
+	for i, data := range window {
+		if binary.BigEndian.Uint16(data[2:4]) == uint16(ack) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// receive writes the payload of each in-order DATA packet from the peer to
+// w. It acknowledges the last in-order block once per window, when it
+// detects a gap and after the final block. If pkt is not nil, it is handled
+// as the first packet received. acked is when the peer was last sent an
+// ACK, or the zero time if it is unknown.
+func (t *transfer) receive(w io.Writer, pkt []byte, acked time.Time) error {
+	var (
+		dataPkt Data
+		errPkt  Err
+		buf     = make([]byte, DatagramSize)
+		block   uint16 // the last block received in order
+		count   uint16 // blocks received since the last acknowledgment
+		nacked  bool   // whether the current gap was acknowledged
+		started bool   // whether any block was received in order
+		retries = t.retries
+	)
+
+	_ = t.conn.SetReadDeadline(time.Now().Add(t.rtt.rto))
+
+	for {
+		if pkt == nil {
+			n, err := t.read(buf)
+			if err != nil {
+				if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+					if retries == 0 {
+						return errors.New("exhausted retries")
+					}
+					retries--
+					t.rtt.backoff()
+
+					// the acknowledgment may have been lost; send it again
+					if !started && t.greeting != nil {
+						err = t.write(t.greeting)
+					} else {
+						err = t.ack(block)
+					}
+					if err != nil {
+						return err
+					}
+					t.retransmitted(1)
+					acked = time.Time{} // Karn's algorithm
+					count = 0
+					_ = t.conn.SetReadDeadline(time.Now().Add(t.rtt.rto))
+					continue
+				}
+
+				return fmt.Errorf("waiting for DATA: %w", err)
+			}
+			pkt = buf[:n]
+		}
+
+		switch {
+		case dataPkt.UnmarshalBinary(pkt) == nil:
+			if dataPkt.Block != t.next(block) {
+				// out of order; point the peer at the last block received
+				// in order so it rolls back, but only once per gap
+				if !nacked {
+					if err := t.ack(block); err != nil {
+						return err
+					}
+					nacked = true
+					acked = time.Time{}
+					count = 0
+				}
+				break
+			}
+
+			// the round trip from our ACK to the next window
+			if !acked.IsZero() {
+				t.rtt.sample(time.Since(acked))
+				acked = time.Time{}
+			}
+
+			n, err := io.Copy(w, dataPkt.Payload)
+			t.stats.Bytes += n
+			if err != nil {
+				// the destination failed; let the peer know
+				_ = t.fail(errPacket(err))
+				return err
+			}
+
+			block = t.next(block)
+			count++
+			nacked = false
+			started = true
+			t.progress()
+			last := n < BlockSize
+
+			if last || count == t.windowSize {
+				if err = t.ack(block); err != nil {
+					return err
+				}
+				acked = time.Now()
+				count = 0
+			}
+
+			if last {
+				t.final, t.complete = block, true
+				return nil
+			}
+
+			retries = t.retries
+			_ = t.conn.SetReadDeadline(time.Now().Add(t.rtt.rto))
+		case errPkt.UnmarshalBinary(pkt) == nil:
+			return errPkt
+		}
+
+		pkt = nil
+	}
+}
+
+// close closes the socket. If the final block was received, the socket
+// stays open in the background to acknowledge the final block again should
+// the peer repeat it, as it does if the ACK was lost, so the peer needn't
+// exhaust its retries on a closed port (RFC 1350, section 6).
+func (t *transfer) close() {
+	if !t.complete {
+		_ = t.conn.Close()
+		return
+	}
+
+	go func() {
+		defer func() { _ = t.conn.Close() }()
+
+		var (
+			dataPkt Data
+			buf     = make([]byte, DatagramSize)
+		)
+
+		// the peer's retransmission timeout is likely as long as ours, so
+		// wait twice as long for its repeat to arrive
+		_ = t.conn.SetReadDeadline(time.Now().Add(2 * t.rtt.rto))
+
+		for {
+			n, err := t.read(buf)
+			if err != nil {
+				return
+			}
+
+			// the peer resends its window up to the final block
+			if dataPkt.UnmarshalBinary(buf[:n]) == nil && dataPkt.Block == t.final {
+				if t.ack(t.final) != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// next returns the block number following block.
+func (t *transfer) next(block uint16) uint16 {
+	if block == math.MaxUint16 {
+		return t.rollover
+	}
+
+	return block + 1
+}
+
+// parseRollover returns the rollover block number in v, which must be
+// either 0 or 1.
+func parseRollover(v string) (uint16, error) {
+	switch v {
+	case "0":
+		return 0, nil
+	case "1":
+		return 1, nil
+	}
+
+	return 0, fmt.Errorf("invalid rollover %q", v)
+}
+
+// parseWindowSize returns the window size in v if it is in the range
+// allowed by RFC 7440.
+func parseWindowSize(v string) (uint16, error) {
+	size, err := strconv.ParseUint(v, 10, 16)
+	if err != nil || size == 0 {
+		return 0, fmt.Errorf("invalid window size %q", v)
+	}
+
+	return uint16(size), nil
+}
+
+// parseOffset returns the byte offset in v.
+func parseOffset(v string) (int64, error) {
+	offset, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid offset %q", v)
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,212 @@
+package histogram
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BucketsPerDoubling is the number of buckets between each power of two
+// nanoseconds. Each bucket's upper bound is about 4.4% above its lower bound,
+// so quantiles are accurate to within about 2.2% at any scale.
+const BucketsPerDoubling = 16
+
+// Histogram counts durations in logarithmic buckets, so it takes little
+// memory however many durations it records and however widely they range.
+// The zero value is an empty histogram ready to use. It is safe for
+// concurrent use.
+type Histogram struct {
+	mu       sync.Mutex
+	buckets  map[int]int // counts of durations by bucket
+	zero     int         // count of durations of 0 or less
+	count    int
+	min, max time.Duration
+}
+
+// bucket returns the index of the bucket of d. As d must be positive, the
+// index is 0 or more.
+func bucket(d time.Duration) int {
+	return int(math.Floor(math.Log2(float64(d)) * BucketsPerDoubling))
+}
+
+// bounds returns the lower and upper bounds of bucket i in nanoseconds.
+func bounds(i int) (float64, float64) {
+	return math.Exp2(float64(i) / BucketsPerDoubling), math.Exp2(float64(i+1) / BucketsPerDoubling)
+}
+
+// Record adds d to the histogram.
+func (h *Histogram) Record(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.count++
+	if h.count == 1 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+
+	if d <= 0 {
+		h.zero++
+		return
+	}
+
+	if h.buckets == nil {
+		h.buckets = make(map[int]int)
+	}
+	h.buckets[bucket(d)]++
+}
+
+// Count returns the number of durations recorded.
+func (h *Histogram) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.count
+}
+
+// Min returns the shortest duration recorded, or 0 if none were.
+func (h *Histogram) Min() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.min
+}
+
+// Max returns the longest duration recorded, or 0 if none were.
+func (h *Histogram) Max() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.max
+}
+
+// indexes returns the indexes of the buckets with counts in order.
+func (h *Histogram) indexes() []int {
+	indexes := make([]int, 0, len(h.buckets))
+	for i := range h.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	return indexes
+}
+
+// Quantile returns the duration that q of the recorded durations are at or
+// below, such as 0.99 for the 99th percentile. It returns the geometric
+// middle of the bucket the duration falls in, limited to the shortest and
+// longest durations recorded, or 0 if none were.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return 0
+	}
+
+	// the rank of the duration, counting from 1
+	rank := int(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	seen := h.zero
+	if seen >= rank {
+		return h.min
+	}
+
+	for _, i := range h.indexes() {
+		seen += h.buckets[i]
+		if seen >= rank {
+			lo, hi := bounds(i)
+			d := time.Duration(math.Sqrt(lo * hi))
+
+			switch {
+			case d < h.min:
+				return h.min
+			case d > h.max:
+				return h.max
+			default:
+				return d
+			}
+		}
+	}
+
+	return h.max
+}
+
+// Print writes an ASCII chart of the histogram to w, with a row for each
+// power of two nanoseconds between the shortest and longest durations
+// recorded and bars at most width characters long.
+func (h *Histogram) Print(w io.Writer, width int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	type row struct {
+		label string
+		count int
+	}
+
+	var rows []row
+	if h.zero > 0 {
+		rows = append(rows, row{label: "<= 0", count: h.zero})
+	}
+
+	indexes := h.indexes()
+	if len(indexes) > 0 {
+		first := indexes[0] / BucketsPerDoubling
+		last := indexes[len(indexes)-1] / BucketsPerDoubling
+
+		counts := make([]int, last-first+1)
+		for _, i := range indexes {
+			counts[i/BucketsPerDoubling-first] += h.buckets[i]
+		}
+
+		for i, count := range counts {
+			lo, hi := math.Exp2(float64(first+i)), math.Exp2(float64(first+i+1))
+			rows = append(rows, row{
+				label: fmt.Sprintf("%s - %s", round(lo), round(hi)),
+				count: count,
+			})
+		}
+	}
+
+	most, labelWidth := 0, 0
+	for _, r := range rows {
+		if r.count > most {
+			most = r.count
+		}
+		if n := len([]rune(r.label)); n > labelWidth {
+			labelWidth = n
+		}
+	}
+
+	for _, r := range rows {
+		bar := 0
+		if r.count > 0 {
+			// show every nonempty row
+			bar = int(math.Max(1, math.Round(float64(r.count)/float64(most)*float64(width))))
+		}
+
+		pad := labelWidth - len([]rune(r.label))
+		_, _ = fmt.Fprintf(w, "%s%s |%-*s| %d\n",
+			strings.Repeat(" ", pad), r.label, width, strings.Repeat("#", bar), r.count)
+	}
+}
+
+// round returns ns nanoseconds as a duration rounded to three significant
+// figures, for labels that are easy to read.
+func round(ns float64) time.Duration {
+	if ns < 1000 {
+		return time.Duration(math.Round(ns))
+	}
+
+	unit := math.Pow(10, math.Floor(math.Log10(ns))-2)
+
+	return time.Duration(math.Round(ns/unit) * unit)
+}
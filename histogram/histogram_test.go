@@ -0,0 +1,104 @@
+package histogram
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuantile(t *testing.T) {
+	var h Histogram
+
+	// 1ms to 1000ms in 1ms steps
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	if h.Count() != 1000 || h.Min() != time.Millisecond || h.Max() != time.Second {
+		t.Fatalf("expected 1000 durations from 1ms to 1s; actual %d from %s to %s", h.Count(), h.Min(), h.Max())
+	}
+
+	for _, test := range []struct {
+		q        float64
+		expected time.Duration
+	}{
+		{0, time.Millisecond},
+		{0.5, 500 * time.Millisecond},
+		{0.9, 900 * time.Millisecond},
+		{0.99, 990 * time.Millisecond},
+		{0.999, 999 * time.Millisecond},
+		{1, time.Second},
+	} {
+		actual := h.Quantile(test.q)
+		if err := math.Abs(float64(actual-test.expected)) / float64(test.expected); err > 0.025 {
+			t.Errorf("q%v: expected about %s; actual %s", test.q, test.expected, actual)
+		}
+	}
+}
+
+func TestQuantileLimits(t *testing.T) {
+	var h Histogram
+	if h.Quantile(0.5) != 0 {
+		t.Error("expected 0 for an empty histogram")
+	}
+
+	h.Record(0)
+	h.Record(3 * time.Millisecond)
+
+	// a bucket's middle is never outside the recorded durations
+	if h.Quantile(0.5) != 0 || h.Quantile(1) != 3*time.Millisecond {
+		t.Errorf("expected 0 and 3ms; actual %s and %s", h.Quantile(0.5), h.Quantile(1))
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var h Histogram
+	for i := 0; i < 30; i++ {
+		h.Record(1500 * time.Microsecond) // between 2^20 and 2^21 ns
+	}
+	for i := 0; i < 10; i++ {
+		h.Record(5 * time.Millisecond) // between 2^22 and 2^23 ns
+	}
+
+	buf := new(bytes.Buffer)
+	h.Print(buf, 30)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	expected := []string{
+		"1.05ms - 2.1ms |" + strings.Repeat("#", 30) + "| 30",
+		" 2.1ms - 4.19ms |" + strings.Repeat(" ", 30) + "| 0",
+		"4.19ms - 8.39ms |" + strings.Repeat("#", 10) + strings.Repeat(" ", 20) + "| 10",
+	}
+
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines; actual:\n%s", len(expected), buf)
+	}
+	for i := range expected {
+		if strings.TrimSpace(lines[i]) != strings.TrimSpace(expected[i]) {
+			t.Errorf("expected %q; actual %q", expected[i], lines[i])
+		}
+	}
+}
+
+func TestConcurrentRecord(t *testing.T) {
+	var h Histogram
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 1; j <= 100; j++ {
+				h.Record(time.Duration(j) * time.Microsecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if h.Count() != 1000 {
+		t.Errorf("expected 1000 durations; actual %d", h.Count())
+	}
+}
@@ -0,0 +1,167 @@
+package lossy
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Config describes the impairments applied to outgoing datagrams. Rates are
+// probabilities between 0 and 1. Wrap both ends of a conversation to impair
+// both directions.
+type Config struct {
+	Seed       int64         // seeds the decisions so a run can be repeated
+	Drop       float64       // rate of datagrams silently discarded
+	Duplicate  float64       // rate of datagrams sent twice
+	Delay      float64       // rate of datagrams sent late
+	MaxDelay   time.Duration // upper bound of the delay; defaults to 10ms
+	Reorder    float64       // rate of datagrams held back until after the next one
+	Corrupt    float64       // rate of datagrams with a flipped bit
+	CorruptLen int           // limits corruption to the first CorruptLen bytes; 0 means anywhere
+}
+
+// Stats counts the impairments applied so far.
+type Stats struct {
+	Dropped    int
+	Duplicated int
+	Delayed    int
+	Reordered  int
+	Corrupted  int
+}
+
+// PacketConn wraps a net.PacketConn and impairs the datagrams written to it
+// according to its Config. Reads pass through untouched.
+type PacketConn struct {
+	net.PacketConn
+
+	mu    sync.Mutex
+	cfg   Config
+	rand  *rand.Rand
+	held  *datagram // the datagram being reordered, if any
+	stats Stats
+}
+
+type datagram struct {
+	p    []byte
+	addr net.Addr
+}
+
+func NewPacketConn(conn net.PacketConn, cfg Config) *PacketConn {
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 10 * time.Millisecond
+	}
+
+	return &PacketConn{
+		PacketConn: conn,
+		cfg:        cfg,
+		rand:       rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+// Stats returns the impairments applied so far.
+func (c *PacketConn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// WriteTo implements the net.PacketConn interface. Impaired datagrams are
+// reported as written in full, just as the network would.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// decide every impairment up front so that the sequence of random
+	// numbers depends only on the number of writes
+	var (
+		drop      = c.rand.Float64() < c.cfg.Drop
+		duplicate = c.rand.Float64() < c.cfg.Duplicate
+		delay     = c.rand.Float64() < c.cfg.Delay
+		reorder   = c.rand.Float64() < c.cfg.Reorder
+		corrupt   = c.rand.Float64() < c.cfg.Corrupt
+		after     = time.Duration(c.rand.Int63n(int64(c.cfg.MaxDelay)) + 1)
+		bit       = c.rand.Intn(8)
+		offset    = c.rand.Int()
+	)
+
+	if drop {
+		c.stats.Dropped++
+		return len(p), nil
+	}
+
+	// copy p since the caller may reuse it before a delayed or held
+	// datagram is sent
+	d := &datagram{p: append([]byte(nil), p...), addr: addr}
+
+	if corrupt && len(d.p) > 0 {
+		n := len(d.p)
+		if c.cfg.CorruptLen > 0 && c.cfg.CorruptLen < n {
+			n = c.cfg.CorruptLen
+		}
+		d.p[offset%n] ^= 1 << bit
+		c.stats.Corrupted++
+	}
+
+	copies := 1
+	if duplicate {
+		copies++
+		c.stats.Duplicated++
+	}
+
+	switch {
+	case delay:
+		c.stats.Delayed++
+		time.AfterFunc(after, func() {
+			for i := 0; i < copies; i++ {
+				_, _ = c.PacketConn.WriteTo(d.p, d.addr)
+			}
+		})
+		return len(p), nil
+	case reorder && c.held == nil:
+		// hold the datagram until the next one is written, or until the
+		// maximum delay if nothing else is written
+		c.stats.Reordered++
+		c.held = d
+		time.AfterFunc(c.cfg.MaxDelay, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			if c.held == d {
+				c.flush()
+			}
+		})
+		if copies == 1 {
+			return len(p), nil
+		}
+		copies-- // the other copy goes out now
+	}
+
+	var err error
+	for i := 0; i < copies; i++ {
+		if _, err = c.PacketConn.WriteTo(d.p, d.addr); err != nil {
+			break
+		}
+	}
+
+	if c.held != d {
+		c.flush()
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// flush sends the held datagram, if any. The caller must hold c.mu.
+func (c *PacketConn) flush() {
+	if c.held == nil {
+		return
+	}
+
+	_, _ = c.PacketConn.WriteTo(c.held.p, c.held.addr)
+	c.held = nil
+}
@@ -0,0 +1,146 @@
+package lossy
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// pair returns a lossy sender and a plain receiver on loopback.
+func pair(t *testing.T, cfg Config) (*PacketConn, net.PacketConn) {
+	t.Helper()
+
+	sender, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sender.Close() })
+
+	receiver, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = receiver.Close() })
+
+	return NewPacketConn(sender, cfg), receiver
+}
+
+// receive reads datagrams until none arrive for a while.
+func receive(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+
+	var msgs []string
+	buf := make([]byte, 1024)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+				return msgs
+			}
+			t.Fatal(err)
+		}
+		msgs = append(msgs, string(buf[:n]))
+	}
+}
+
+func send(t *testing.T, c *PacketConn, to net.Addr, msgs ...string) {
+	t.Helper()
+
+	for _, msg := range msgs {
+		n, err := c.WriteTo([]byte(msg), to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(msg) {
+			t.Fatalf("wrote %d bytes of %d", n, len(msg))
+		}
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		expected []string
+		sorted   bool // whether the order of arrival is random
+	}{
+		{"none", Config{}, []string{"a", "b", "c"}, false},
+		{"drop", Config{Drop: 1}, nil, false},
+		{"duplicate", Config{Duplicate: 1}, []string{"a", "a", "b", "b", "c", "c"}, false},
+		// delayed datagrams may overtake each other
+		{"delay", Config{Delay: 1, MaxDelay: time.Millisecond}, []string{"a", "b", "c"}, true},
+		// the first datagram is held until after the second, and so on
+		{"reorder", Config{Reorder: 1}, []string{"b", "a", "c"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, r := pair(t, test.cfg)
+			send(t, c, r.LocalAddr(), "a", "b", "c")
+
+			actual := receive(t, r)
+			if test.sorted {
+				sort.Strings(actual)
+			}
+
+			if !reflect.DeepEqual(test.expected, actual) {
+				t.Errorf("expected %q; actual %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPacketConnCorrupt(t *testing.T) {
+	c, r := pair(t, Config{Corrupt: 1, CorruptLen: 1})
+
+	msg := "\x00rest of the datagram"
+	send(t, c, r.LocalAddr(), msg)
+
+	actual := receive(t, r)
+	if len(actual) != 1 {
+		t.Fatalf("expected 1 datagram; actual %d", len(actual))
+	}
+
+	// exactly one bit of the first byte differs
+	if actual[0][1:] != msg[1:] {
+		t.Errorf("corruption beyond the first byte: %q", actual[0])
+	}
+	if b := actual[0][0]; b == 0 || b&(b-1) != 0 {
+		t.Errorf("expected a single flipped bit; actual %08b", b)
+	}
+
+	if s := c.Stats(); s.Corrupted != 1 {
+		t.Errorf("expected 1 corrupted datagram; actual %d", s.Corrupted)
+	}
+}
+
+func TestPacketConnSeed(t *testing.T) {
+	cfg := Config{Seed: 42, Drop: 0.3, Duplicate: 0.3}
+
+	var msgs []string
+	for i := 0; i < 50; i++ {
+		msgs = append(msgs, fmt.Sprint(i))
+	}
+
+	// run returns the datagrams delivered for the same sequence of writes
+	run := func() []string {
+		c, r := pair(t, cfg)
+		send(t, c, r.LocalAddr(), msgs...)
+
+		return receive(t, r)
+	}
+
+	first, second := run(), run()
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("same seed delivered different datagrams:\n%q\n%q", first, second)
+	}
+
+	if reflect.DeepEqual(msgs, first) {
+		t.Fatal("expected impairments")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultBuckets are the upper bounds, in seconds, of the probe duration
+// histogram's buckets.
+var defaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metrics collects the outcome of probes and serves them in the Prometheus
+// text format.
+type metrics struct {
+	mu      sync.Mutex
+	buckets []float64
+	targets map[string]*targetMetrics
+}
+
+type targetMetrics struct {
+	probes    uint64
+	succeeded uint64
+	counts    []uint64 // of successful probes in each bucket, not cumulative
+	sum       float64  // of the durations of successful probes, in seconds
+	lastErr   error    // of the last probe
+}
+
+func newMetrics(buckets []float64) *metrics {
+	return &metrics{buckets: buckets, targets: make(map[string]*targetMetrics)}
+}
+
+// add adds target, so it's exported before it's probed.
+func (m *metrics) add(target string) *targetMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.target(target)
+}
+
+func (m *metrics) target(name string) *targetMetrics {
+	t, ok := m.targets[name]
+	if !ok {
+		t = &targetMetrics{counts: make([]uint64, len(m.buckets))}
+		m.targets[name] = t
+	}
+
+	return t
+}
+
+// observe records the outcome of a probe of target.
+func (m *metrics) observe(target string, ph phases, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.target(target)
+	t.probes++
+	t.lastErr = err
+
+	if err != nil {
+		return
+	}
+
+	t.succeeded++
+
+	secs := ph.sample().Seconds()
+	t.sum += secs
+
+	// the +Inf bucket is the count of successful probes
+	if i := sort.SearchFloat64s(m.buckets, secs); i < len(m.buckets) {
+		t.counts[i]++
+	}
+}
+
+// labelEscaper escapes label values as the text format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(name, value string) string {
+	return fmt.Sprintf("%s=\"%s\"", name, labelEscaper.Replace(value))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.targets))
+	for name := range m.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	defer func() { _ = bw.Flush() }()
+
+	// each metric family is written in one group
+	family := func(name, typ, help string, sample func(target string, t *targetMetrics)) {
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, target := range names {
+			sample(label("target", target), m.targets[target])
+		}
+	}
+
+	family("ping_probes_total", "counter", "Probes of the target.",
+		func(target string, t *targetMetrics) {
+			_, _ = fmt.Fprintf(bw, "ping_probes_total{%s} %d\n", target, t.probes)
+		})
+
+	family("ping_probe_successes_total", "counter", "Successful probes of the target.",
+		func(target string, t *targetMetrics) {
+			_, _ = fmt.Fprintf(bw, "ping_probe_successes_total{%s} %d\n", target, t.succeeded)
+		})
+
+	family("ping_up", "gauge", "Whether the last probe of the target succeeded.",
+		func(target string, t *targetMetrics) {
+			up := 0
+			if t.probes > 0 && t.lastErr == nil {
+				up = 1
+			}
+			_, _ = fmt.Fprintf(bw, "ping_up{%s} %d\n", target, up)
+		})
+
+	// only the class is a label: the error's text often holds an ephemeral
+	// port, which would make a new series of every failure
+	family("ping_last_error", "gauge", "The class of error of the last probe of the target, if it failed.",
+		func(target string, t *targetMetrics) {
+			if t.lastErr != nil {
+				_, _ = fmt.Fprintf(bw, "ping_last_error{%s,%s} 1\n", target, label("class", classify(t.lastErr)))
+			}
+		})
+
+	family("ping_probe_duration_seconds", "histogram", "Durations of successful probes of the target.",
+		func(target string, t *targetMetrics) {
+			var cumulative uint64
+			for i, le := range m.buckets {
+				cumulative += t.counts[i]
+				_, _ = fmt.Fprintf(bw, "ping_probe_duration_seconds_bucket{%s,%s} %d\n",
+					target, label("le", formatFloat(le)), cumulative)
+			}
+			_, _ = fmt.Fprintf(bw, "ping_probe_duration_seconds_bucket{%s,%s} %d\n",
+				target, label("le", "+Inf"), t.succeeded)
+			_, _ = fmt.Fprintf(bw, "ping_probe_duration_seconds_sum{%s} %s\n", target, formatFloat(t.sum))
+			_, _ = fmt.Fprintf(bw, "ping_probe_duration_seconds_count{%s} %d\n", target, t.succeeded)
+		})
+}
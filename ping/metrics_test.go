@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics([]float64{.01, .1})
+	m.add("idle:80")
+
+	m.observe("web:443", phases{connect: 5 * time.Millisecond}, nil)
+	m.observe("web:443", phases{connect: 50 * time.Millisecond}, nil)
+	m.observe("web:443", phases{connect: 500 * time.Millisecond}, nil)
+	m.observe("db:5432", phases{}, errors.New(`refused "now"`))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE ping_probes_total counter",
+		`ping_probes_total{target="db:5432"} 1`,
+		`ping_probes_total{target="idle:80"} 0`,
+		`ping_probe_successes_total{target="web:443"} 3`,
+		`ping_up{target="db:5432"} 0`,
+		`ping_up{target="idle:80"} 0`,
+		`ping_up{target="web:443"} 1`,
+		`ping_last_error{target="db:5432",class="error"} 1`,
+		"# TYPE ping_probe_duration_seconds histogram",
+		`ping_probe_duration_seconds_bucket{target="web:443",le="0.01"} 1`,
+		`ping_probe_duration_seconds_bucket{target="web:443",le="0.1"} 2`,
+		`ping_probe_duration_seconds_bucket{target="web:443",le="+Inf"} 3`,
+		`ping_probe_duration_seconds_sum{target="web:443"} 0.555`,
+		`ping_probe_duration_seconds_count{target="web:443"} 3`,
+		`ping_probe_duration_seconds_count{target="db:5432"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+
+	if strings.Contains(body, "refused") {
+		t.Errorf("expected no error text in labels:\n%s", body)
+	}
+	if strings.Contains(body, `ping_last_error{target="web:443"`) {
+		t.Errorf("expected no last error of a target that's up:\n%s", body)
+	}
+
+	// targets are sorted within each family
+	if strings.Index(body, `ping_up{target="db:5432"}`) > strings.Index(body, `ping_up{target="web:443"}`) {
+		t.Errorf("expected targets in order:\n%s", body)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// readTargets returns the host:port targets listed in the file at path, one
+// per line. Blank lines and lines starting with # are ignored.
+func readTargets(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var targets []string
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+
+	return targets, s.Err()
+}
+
+// target is one of many targets probed concurrently.
+type target struct {
+	name  string
+	p     *prober
+	stats stats
+	last  phases
+	err   error // of the last probe
+	busy  bool  // whether a probe is in flight
+}
+
+// probe probes the target, resolving it first if it's resolved only once
+// and that hasn't succeeded yet.
+func (t *target) probe() (phases, error) {
+	if !t.p.resolveEach && t.p.resolved == "" {
+		if dns, err := t.p.init(); err != nil {
+			return phases{dns: dns}, err
+		}
+	}
+
+	return t.p.probe()
+}
+
+func (t *target) status() string {
+	switch {
+	case t.stats.sent == 0:
+		return "waiting"
+	case t.err != nil:
+		if class := classify(t.err); class != classOther {
+			return "down: " + class
+		}
+		return "down: " + t.err.Error()
+	default:
+		return "up"
+	}
+}
+
+// result is the outcome of a probe of target.
+type result struct {
+	target *target
+	start  time.Time
+	ph     phases
+	err    error
+}
+
+// pool probes targets concurrently with at most workers probes in flight.
+// Each target is probed once per interval, unless its previous probe is
+// still in flight, until it's been probed count times.
+type pool struct {
+	targets  []*target
+	workers  int
+	count    int // <= 0 means forever
+	interval time.Duration
+	report   func(t *target, r result) // called with each result, if not nil
+}
+
+// run probes the targets until they've all been probed count times or stop
+// receives. It calls draw every refresh and once more before returning.
+func (p *pool) run(stop <-chan os.Signal, refresh time.Duration, draw func([]*target)) {
+	// each target has at most one probe in flight, so neither channel blocks
+	jobs := make(chan *target, len(p.targets))
+	results := make(chan result, len(p.targets))
+	defer close(jobs)
+
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			for t := range jobs {
+				start := time.Now()
+				ph, err := t.probe()
+				results <- result{target: t, start: start, ph: ph, err: err}
+			}
+		}()
+	}
+
+	probes := time.NewTicker(p.interval)
+	defer probes.Stop()
+	redraw := time.NewTicker(refresh)
+	defer redraw.Stop()
+
+	defer func() { draw(p.targets) }()
+
+	p.schedule(jobs)
+
+	for !p.done() {
+		select {
+		case r := <-results:
+			t := r.target
+			t.busy = false
+			t.last, t.err = r.ph, r.err
+			if r.err != nil {
+				t.stats.fail()
+			} else {
+				t.stats.add(r.ph.sample())
+			}
+			if p.report != nil {
+				p.report(t, r)
+			}
+		case <-probes.C:
+			p.schedule(jobs)
+		case <-redraw.C:
+			draw(p.targets)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// schedule queues a probe of each target that's idle and due another one.
+func (p *pool) schedule(jobs chan<- *target) {
+	for _, t := range p.targets {
+		if t.busy || (p.count > 0 && t.stats.sent >= p.count) {
+			continue
+		}
+		t.busy = true
+		jobs <- t
+	}
+}
+
+func (p *pool) done() bool {
+	if p.count <= 0 {
+		return false
+	}
+
+	for _, t := range p.targets {
+		if t.busy || t.stats.sent < p.count {
+			return false
+		}
+	}
+
+	return true
+}
+
+// render writes a table of the targets' latest state to w.
+func render(w io.Writer, targets []*target) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "TARGET\tSENT\tLOSS\tLAST\tAVG\tSTATUS")
+
+	for _, t := range targets {
+		last, avg := "-", "-"
+		if t.stats.sent > 0 && t.err == nil {
+			last = t.last.sample().String()
+		}
+		if t.stats.succeeded > 0 {
+			avg = t.stats.avg().String()
+		}
+
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%s\t%s\t%s\n",
+			t.name, t.stats.sent, t.stats.loss(), last, avg, t.status())
+	}
+
+	_ = tw.Flush()
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
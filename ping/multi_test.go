@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets")
+	err := os.WriteFile(path, []byte("# routers\nexample.com:80\n\n  10.0.0.1:22  \n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := readTargets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"example.com:80", "10.0.0.1:22"}
+	if !reflect.DeepEqual(expected, targets) {
+		t.Errorf("expected %q; actual %q", expected, targets)
+	}
+}
+
+func TestPool(t *testing.T) {
+	// a target nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	_ = l.Close()
+
+	names := []string{listen(t), closed, listen(t)}
+
+	p := &pool{workers: 2, count: 3, interval: 10 * time.Millisecond}
+	for _, name := range names {
+		p.targets = append(p.targets, &target{name: name, p: &prober{target: name, timeout: time.Second}})
+	}
+
+	draws := 0
+	p.run(nil, time.Hour, func([]*target) { draws++ })
+
+	if draws != 1 {
+		t.Errorf("expected a final draw; actual %d draws", draws)
+	}
+
+	for i, tg := range p.targets {
+		expected := 3
+		if i == 1 {
+			expected = 0
+		}
+		if tg.stats.sent != 3 || tg.stats.succeeded != expected {
+			t.Errorf("%s: expected 3 sent, %d succeeded; actual %d, %d",
+				tg.name, expected, tg.stats.sent, tg.stats.succeeded)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	render(buf, p.targets)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "TARGET") {
+		t.Fatalf("unexpected table:\n%s", buf)
+	}
+	if fields := strings.Fields(lines[1]); fields[3] == "-" || fields[5] != "up" {
+		t.Errorf("expected an up target with a latency; actual %q", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); fields[2] != "100.0%" || fields[3] != "-" || fields[5] != "down:" {
+		t.Errorf("expected a down target; actual %q", lines[2])
+	}
+}
+
+func TestPoolStop(t *testing.T) {
+	p := &pool{workers: 1, count: 0, interval: time.Millisecond}
+	p.targets = []*target{{name: "t", p: &prober{target: listen(t), timeout: time.Second}}}
+
+	stop := make(chan os.Signal, 1)
+	time.AfterFunc(50*time.Millisecond, func() { stop <- os.Interrupt })
+
+	p.run(stop, time.Hour, func([]*target) {})
+
+	if p.targets[0].stats.sent == 0 {
+		t.Error("expected probes before stopping")
+	}
+}
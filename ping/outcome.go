@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// classes of a probe's error
+const (
+	classNotFound    = "not-found"   // the host doesn't exist
+	classDNS         = "dns"         // resolving the host failed otherwise
+	classTimeout     = "timeout"     // the probe took longer than the timeout
+	classRefused     = "refused"     // nothing listens on the port
+	classUnreachable = "unreachable" // there's no route to the host or its network
+	classReset       = "reset"       // the peer closed the connection
+	classOther       = "error"
+)
+
+// classify returns the class of a probe's error, or "" for no error.
+func classify(err error) string {
+	var dnsErr *net.DNSError
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, syscall.ETIMEDOUT):
+		return classTimeout
+	case errors.As(err, &dnsErr):
+		switch {
+		case dnsErr.IsTimeout:
+			return classTimeout
+		case dnsErr.IsNotFound:
+			return classNotFound
+		default:
+			return classDNS
+		}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return classRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return classUnreachable
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return classReset
+	default:
+		return classOther
+	}
+}
+
+// exit codes
+const (
+	exitOK      = 0
+	exitDown    = 1 // no probe of a target succeeded
+	exitError   = 2 // the command line is invalid or ping couldn't run
+	exitLoss    = 3 // a target lost more probes than allowed
+	exitLatency = 4 // a target's average latency is higher than allowed
+)
+
+// severity orders the exit codes of outcomes, most severe last.
+var severity = map[int]int{exitOK: 0, exitLatency: 1, exitLoss: 2, exitDown: 3}
+
+// outcome returns the exit code of the probes summarized by s. A maxLoss
+// of 100 or more and a maxLatency of 0 or less disable their checks.
+func outcome(s *stats, maxLoss float64, maxLatency time.Duration) int {
+	switch {
+	case s.succeeded == 0:
+		return exitDown
+	case s.loss() > maxLoss:
+		return exitLoss
+	case maxLatency > 0 && s.avg() > maxLatency:
+		return exitLatency
+	default:
+		return exitOK
+	}
+}
+
+// worst returns the most severe of the exit codes a and b.
+func worst(a, b int) int {
+	if severity[b] > severity[a] {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	_ = l.Close()
+
+	_, refused := (&prober{target: closed, timeout: time.Second}).probe()
+
+	dial := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{nil, ""},
+		{refused, classRefused},
+		{&net.DNSError{Err: "no such host", Name: "nosuch.invalid", IsNotFound: true}, classNotFound},
+		{&net.DNSError{Err: "server misbehaving", Name: "example.com"}, classDNS},
+		{&net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}, classTimeout},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, classTimeout},
+		{dial(syscall.ETIMEDOUT), classTimeout},
+		{dial(syscall.EHOSTUNREACH), classUnreachable},
+		{dial(syscall.ENETUNREACH), classUnreachable},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, classReset},
+		{fmt.Errorf("reading pong: %w", io.EOF), classReset},
+		{errors.New("boom"), classOther},
+	}
+
+	for i, test := range tests {
+		if actual := classify(test.err); actual != test.expected {
+			t.Errorf("%d: expected class %q for %v; actual %q", i, test.expected, test.err, actual)
+		}
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	probes := func(failed int, durations ...time.Duration) *stats {
+		var s stats
+		for _, d := range durations {
+			s.add(d)
+		}
+		for i := 0; i < failed; i++ {
+			s.fail()
+		}
+		return &s
+	}
+
+	tests := []struct {
+		s          *stats
+		maxLoss    float64
+		maxLatency time.Duration
+		expected   int
+	}{
+		{probes(0, time.Millisecond), 100, 0, exitOK},
+		{probes(2), 100, 0, exitDown},
+		{probes(1, time.Millisecond), 100, 0, exitOK},
+		{probes(1, time.Millisecond), 50, 0, exitOK},
+		{probes(1, time.Millisecond), 49.9, 0, exitLoss},
+		{probes(0, time.Millisecond, 3*time.Millisecond), 100, 2 * time.Millisecond, exitOK},
+		{probes(0, time.Millisecond, 5*time.Millisecond), 100, 2 * time.Millisecond, exitLatency},
+		{probes(1, 5*time.Millisecond), 10, 2 * time.Millisecond, exitLoss},
+	}
+
+	for i, test := range tests {
+		if actual := outcome(test.s, test.maxLoss, test.maxLatency); actual != test.expected {
+			t.Errorf("%d: expected exit code %d; actual %d", i, test.expected, actual)
+		}
+	}
+
+	if worst(exitLatency, exitLoss) != exitLoss || worst(exitDown, exitLoss) != exitDown || worst(exitOK, exitLatency) != exitLatency {
+		t.Error("unexpected order of severity")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// types of record
+const (
+	recordProbe   = "probe"
+	recordSummary = "summary"
+)
+
+// record is the machine-readable outcome of a probe or, with a summary,
+// of all probes of a target. Durations are in nanoseconds.
+type record struct {
+	Type     string        `json:"type"`
+	Time     time.Time     `json:"timestamp"`
+	Target   string        `json:"target"`
+	Address  string        `json:"address,omitempty"`       // the resolved address probed
+	Local    string        `json:"local_address,omitempty"` // the address probed from
+	Iface    string        `json:"interface,omitempty"`     // the network interface probed through
+	Attempt  int           `json:"attempt,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Connect  time.Duration `json:"connect,omitempty"` // the TCP handshake, which a pingpong probe's duration leaves out
+	Class    string        `json:"error_class,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Summary  *summary      `json:"summary,omitempty"`
+}
+
+type summary struct {
+	Sent      int           `json:"sent"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Loss      float64       `json:"loss"` // percentage of failed probes
+	Min       time.Duration `json:"min"`
+	Avg       time.Duration `json:"avg"`
+	Max       time.Duration `json:"max"`
+	Stddev    time.Duration `json:"stddev"`
+	P50       time.Duration `json:"p50"`
+	P90       time.Duration `json:"p90"`
+	P99       time.Duration `json:"p99"`
+	P999      time.Duration `json:"p99_9"`
+}
+
+// probeRecord returns the record of attempt at probing target, which
+// started at start.
+func probeRecord(target string, attempt int, start time.Time, ph phases, err error) record {
+	r := record{
+		Type:     recordProbe,
+		Time:     start,
+		Target:   target,
+		Address:  ph.addr,
+		Local:    ph.local,
+		Iface:    ph.iface,
+		Attempt:  attempt,
+		Duration: ph.sample(),
+		Connect:  ph.connect,
+		Class:    classify(err),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+// summaryRecord returns the record summarizing s, the probes of target.
+func summaryRecord(target string, s *stats) record {
+	return record{
+		Type:   recordSummary,
+		Time:   time.Now(),
+		Target: target,
+		Summary: &summary{
+			Sent:      s.sent,
+			Succeeded: s.succeeded,
+			Failed:    s.failed(),
+			Loss:      s.loss(),
+			Min:       s.min,
+			Avg:       s.avg(),
+			Max:       s.max,
+			Stddev:    s.stddev(),
+			P50:       s.hist.Quantile(.5),
+			P90:       s.hist.Quantile(.9),
+			P99:       s.hist.Quantile(.99),
+			P999:      s.hist.Quantile(.999),
+		},
+	}
+}
+
+// recordWriter writes records as soon as they're written, so they can be
+// piped into other tools while probing.
+type recordWriter interface {
+	write(r record) error
+}
+
+// newRecordWriter returns a recordWriter writing the given format to w:
+// "json" for one JSON object per line or "csv" for a header line followed
+// by a line per record. It returns nil for the "text" format.
+func newRecordWriter(w io.Writer, format string) (recordWriter, error) {
+	switch format {
+	case "text":
+		return nil, nil
+	case "json":
+		return jsonWriter{json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+func (j jsonWriter) write(r record) error {
+	return j.enc.Encode(r)
+}
+
+var csvHeader = []string{
+	"type", "timestamp", "target", "address", "local_address", "interface", "attempt", "duration", "connect", "error_class", "error",
+	"sent", "succeeded", "failed", "loss", "min", "avg", "max", "stddev", "p50", "p90", "p99", "p99_9",
+}
+
+// csvColumns maps the names in csvHeader to their indexes.
+var csvColumns = func() map[string]int {
+	columns := make(map[string]int, len(csvHeader))
+	for i, name := range csvHeader {
+		columns[name] = i
+	}
+
+	return columns
+}()
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool // whether the header was written
+}
+
+// write writes r, leaving the columns that don't apply to it empty.
+func (c *csvWriter) write(r record) error {
+	if !c.header {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+		c.header = true
+	}
+
+	line := make([]string, len(csvHeader))
+	set := func(column, value string) { line[csvColumns[column]] = value }
+	duration := func(column string, d time.Duration) { set(column, strconv.FormatInt(int64(d), 10)) }
+
+	set("type", r.Type)
+	set("timestamp", r.Time.Format(time.RFC3339Nano))
+	set("target", r.Target)
+	set("address", r.Address)
+	set("local_address", r.Local)
+	set("interface", r.Iface)
+	set("error_class", r.Class)
+	set("error", r.Error)
+
+	if s := r.Summary; s == nil {
+		set("attempt", strconv.Itoa(r.Attempt))
+		duration("duration", r.Duration)
+		duration("connect", r.Connect)
+	} else {
+		set("sent", strconv.Itoa(s.Sent))
+		set("succeeded", strconv.Itoa(s.Succeeded))
+		set("failed", strconv.Itoa(s.Failed))
+		set("loss", strconv.FormatFloat(s.Loss, 'f', 1, 64))
+		duration("min", s.Min)
+		duration("avg", s.Avg)
+		duration("max", s.Max)
+		duration("stddev", s.Stddev)
+		duration("p50", s.P50)
+		duration("p90", s.P90)
+		duration("p99", s.P99)
+		duration("p99_9", s.P999)
+	}
+
+	if err := c.w.Write(line); err != nil {
+		return err
+	}
+	c.w.Flush()
+
+	return c.w.Error()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRecords() []record {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var s stats
+	s.add(2 * time.Millisecond)
+	s.fail()
+
+	return []record{
+		probeRecord("example.com:80", 1, start, phases{addr: "93.184.216.34:80", local: "192.0.2.1:50000", iface: "eth1", connect: 2 * time.Millisecond}, nil),
+		probeRecord("example.com:80", 2, start.Add(time.Second), phases{addr: "93.184.216.34:80"}, errors.New("boom")),
+		summaryRecord("example.com:80", &s),
+	}
+}
+
+func TestJSONRecords(t *testing.T) {
+	buf := new(bytes.Buffer)
+	rw, err := newRecordWriter(buf, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range testRecords() {
+		if err = rw.write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines; actual:\n%s", buf)
+	}
+
+	var probe, failed, sum map[string]interface{}
+	for i, v := range []*map[string]interface{}{&probe, &failed, &sum} {
+		if err = json.Unmarshal([]byte(lines[i]), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if probe["type"] != "probe" || probe["address"] != "93.184.216.34:80" || probe["local_address"] != "192.0.2.1:50000" || probe["interface"] != "eth1" || probe["attempt"] != 1e0 ||
+		probe["duration"] != 2e6 || probe["timestamp"] != "2021-06-01T12:00:00Z" || probe["error_class"] != nil {
+		t.Errorf("unexpected probe record %s", lines[0])
+	}
+	if failed["error_class"] != classOther || failed["error"] != "boom" || failed["duration"] != 0e0 {
+		t.Errorf("unexpected failed probe record %s", lines[1])
+	}
+
+	s, ok := sum["summary"].(map[string]interface{})
+	if sum["type"] != "summary" || !ok || s["sent"] != 2e0 || s["succeeded"] != 1e0 || s["loss"] != 50e0 || s["p99_9"] != 2e6 {
+		t.Errorf("unexpected summary record %s", lines[2])
+	}
+}
+
+func TestCSVRecords(t *testing.T) {
+	buf := new(bytes.Buffer)
+	rw, err := newRecordWriter(buf, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range testRecords() {
+		if err = rw.write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) != 4 || strings.Join(lines[0], ",") != strings.Join(csvHeader, ",") {
+		t.Fatalf("expected a header and 3 records; actual %q", lines)
+	}
+
+	expected := []map[string]string{
+		{"type": "probe", "timestamp": "2021-06-01T12:00:00Z", "target": "example.com:80", "address": "93.184.216.34:80",
+			"local_address": "192.0.2.1:50000", "interface": "eth1", "attempt": "1", "duration": "2000000", "connect": "2000000"},
+		{"type": "probe", "timestamp": "2021-06-01T12:00:01Z", "target": "example.com:80", "address": "93.184.216.34:80",
+			"attempt": "2", "duration": "0", "connect": "0", "error_class": "error", "error": "boom"},
+		{"type": "summary", "target": "example.com:80", "sent": "2", "succeeded": "1", "failed": "1", "loss": "50.0",
+			"min": "2000000", "avg": "2000000", "max": "2000000", "stddev": "0",
+			"p50": "2000000", "p90": "2000000", "p99": "2000000", "p99_9": "2000000"},
+	}
+
+	for i, columns := range expected {
+		line := lines[i+1]
+
+		for column, index := range csvColumns {
+			value, ok := columns[column]
+			if column == "timestamp" && !ok {
+				// the summary's time is when it was written
+				continue
+			}
+			if line[index] != value {
+				t.Errorf("line %d: expected %s %q; actual %q", i+1, column, value, line[index])
+			}
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if rw, err := newRecordWriter(new(bytes.Buffer), "text"); rw != nil || err != nil {
+		t.Errorf("expected no record writer for text; actual %v, %v", rw, err)
+	}
+	if _, err := newRecordWriter(new(bytes.Buffer), "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -1,66 +1,405 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var (
-	count    = flag.Int("c", 3, "number of pings: <= 0 means forever")
-	interval = flag.Duration("i", time.Second, "interval between pings")
-	timeout  = flag.Duration("W", 5*time.Second, "time to wait for a reply")
+	count       = flag.Int("c", 3, "number of pings: <= 0 means forever")
+	interval    = flag.Duration("i", time.Second, "interval between pings")
+	timeout     = flag.Duration("W", 5*time.Second, "time to wait for a reply")
+	showPhases  = flag.Bool("phases", false, "report DNS, TCP connect, TLS handshake and round-trip times separately")
+	mode        = flag.String("m", modeTCP, "probe mode: tcp to connect, udp to time an echoed datagram or pingpong to time a ping's pong over one TCP connection")
+	useTLS      = flag.Bool("tls", false, "perform a TLS handshake after connecting over TCP")
+	insecure    = flag.Bool("k", false, "skip verification of the server's TLS certificate")
+	source      = flag.String("S", "", "source address to connect from: an IP address, :port or both")
+	ipv4        = flag.Bool("4", false, "use IPv4 only")
+	ipv6        = flag.Bool("6", false, "use IPv6 only")
+	iface       = flag.String("I", "", "network interface to send through, such as eth1 (Linux only)")
+	mark        = flag.Int("mark", 0, "SO_MARK to set on each socket, for policy routing (Linux only)")
+	noDelay     = flag.Bool("nodelay", true, "set TCP_NODELAY on TCP connections")
+	resolveEach = flag.Bool("resolve-each", false, "resolve the host for every ping rather than once")
+	file        = flag.String("f", "", "file listing targets, one host:port per line")
+	workers     = flag.Int("workers", 10, "maximum concurrent pings when pinging many targets")
+	refresh     = flag.Duration("refresh", time.Second, "interval between table updates when pinging many targets")
+	output      = flag.String("o", "text", "output format: text, json or csv")
+	maxLoss     = flag.Float64("max-loss", 100, "percentage of lost pings above which to exit with status 3")
+	maxLatency  = flag.Duration("max-latency", 0, "average latency above which to exit with status 4: 0 means any")
+	sweepPorts  = flag.String("sweep", "", "sweep these ports of a host, such as 22,80,8000-8100, reporting which are open")
+	rate        = flag.Int("rate", 100, "maximum ports swept per second: <= 0 means unlimited")
+	metricsAddr = flag.String("metrics", "", "probe forever, serving Prometheus metrics at /metrics on this address")
 )
 
 func init() {
 	flag.Usage = func() {
-		fmt.Printf("Usage: %s [options] host:port\nOptions:\n", os.Args[0])
+		fmt.Printf("Usage: %s [options] host:port [host:port ...]\nOptions:\n", os.Args[0])
 		flag.PrintDefaults()
+		fmt.Print("Exit status:\n",
+			"  0 if every target replied within the thresholds\n",
+			"  1 if a target never replied or a sweep found no open port\n",
+			"  2 if the command line is invalid or ping couldn't run\n",
+			"  3 if a target lost more pings than -max-loss allows\n",
+			"  4 if a target's average latency exceeded -max-latency\n")
 	}
 }
 
 func main() {
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	targets := flag.Args()
+	if *file != "" {
+		listed, err := readTargets(*file)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(exitError)
+		}
+		targets = append(targets, listed...)
+	}
+
+	if len(targets) == 0 {
 		fmt.Print("host: port is required\n\n")
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitError)
+	}
+
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	switch *mode {
+	case modeTCP, modePingPong:
+	case modeUDP:
+		if *useTLS {
+			fmt.Print("-tls requires a TCP probe mode\n\n")
+			flag.Usage()
+			os.Exit(exitError)
+		}
+	default:
+		fmt.Printf("unknown probe mode %q\n\n", *mode)
+		flag.Usage()
+		os.Exit(exitError)
+	}
+
+	if *ipv4 && *ipv6 {
+		fmt.Print("-4 and -6 are mutually exclusive\n\n")
+		flag.Usage()
+		os.Exit(exitError)
+	}
+
+	if _, _, err := parseSource(*source); err != nil {
+		fmt.Print(err, "\n\n")
+		flag.Usage()
+		os.Exit(exitError)
+	}
+
+	rw, err := newRecordWriter(os.Stdout, *output)
+	if err != nil {
+		fmt.Print(err, "\n\n")
+		flag.Usage()
+		os.Exit(exitError)
+	}
+
+	// stop early on CTRL+C, but still print the summary
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	switch {
+	case *sweepPorts != "":
+		if len(targets) != 1 || *file != "" || *mode != modeTCP {
+			fmt.Print("-sweep requires a single host and the tcp probe mode\n\n")
+			flag.Usage()
+			os.Exit(exitError)
+		}
+		sweepHost(targets[0], rw, interrupt)
+	case *metricsAddr != "":
+		serveMetrics(targets, rw, interrupt)
+	case len(targets) == 1 && *file == "":
+		pingOne(targets[0], rw, interrupt)
+	default:
+		pingMany(targets, rw, interrupt)
+	}
+}
+
+// newProber returns a prober of target configured by the flags.
+func newProber(target string) *prober {
+	p := &prober{
+		target:      target,
+		mode:        *mode,
+		timeout:     *timeout,
+		resolveEach: *resolveEach,
+		iface:       *iface,
+		mark:        *mark,
+		delay:       !*noDelay,
+	}
+
+	// main validated the source address
+	p.sourceIP, p.sourcePort, _ = parseSource(*source)
+
+	switch {
+	case *ipv4:
+		p.family = "4"
+	case *ipv6:
+		p.family = "6"
+	case p.sourceIP != nil && p.sourceIP.To4() != nil:
+		// connect over the source address's family
+		p.family = "4"
+	case p.sourceIP != nil:
+		p.family = "6"
+	}
+	if *useTLS {
+		host, _, _ := net.SplitHostPort(target)
+		p.tls = &tls.Config{ServerName: host, InsecureSkipVerify: *insecure}
 	}
 
-	target := flag.Arg(0)
-	fmt.Println("PING", target)
+	return p
+}
 
-	if *count <= 0 {
-		fmt.Println("CTRL+C to stop.")
+// emit writes r with rw, exiting if it fails, such as when the output is
+// piped into a tool that exited.
+func emit(rw recordWriter, r record) {
+	if err := rw.write(r); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
 	}
+}
+
+// pingOne pings target, printing the outcome of each attempt, or writing
+// it with rw if not nil.
+func pingOne(target string, rw recordWriter, interrupt <-chan os.Signal) {
+	p := newProber(target)
+
+	dns, err := p.init()
+	if err != nil {
+		if rw != nil {
+			var s stats
+			s.fail()
 
-	msg := 0
+			emit(rw, probeRecord(target, 1, time.Now(), phases{dns: dns}, err))
+			emit(rw, summaryRecord(target, &s))
+		} else {
+			fmt.Printf("PING %s: fail in %s (%s): %v\n", target, dns, classify(err), err)
+		}
+		os.Exit(exitDown)
+	}
 
-	// Attempt to make connection to socket while msg is less
-	// than count (default is set to 3, but flag can be adjusted)
-	for (*count <= 0) || (msg < *count) {
-		msg++
-		fmt.Printf("Attempt %d: ", msg)
+	if rw == nil {
+		if p.resolved != "" && p.resolved != target {
+			fmt.Printf("PING %s (%s): resolved in %s\n", target, p.resolved, dns)
+		} else {
+			fmt.Println("PING", target)
+		}
+
+		if *count <= 0 {
+			fmt.Println("CTRL+C to stop.")
+		}
+	}
+
+	var s stats
+
+PROBES:
+	for msg := 1; (*count <= 0) || (msg <= *count); msg++ {
+		if msg > 1 {
+			select {
+			case <-time.After(*interval):
+			case <-interrupt:
+				break PROBES
+			}
+		}
+
+		if rw == nil {
+			fmt.Printf("Attempt %d: ", msg)
+		}
 
 		start := time.Now()
-		c, err := net.DialTimeout("tcp", target, *timeout)
-		dur := time.Since(start)
+		ph, err := p.probe()
+		dur := ph.sample()
 
 		if err != nil {
-			fmt.Printf("fail in %s: %v\n", dur, err)
-			if nErr, ok := err.(net.Error); !ok || !nErr.Temporary() {
-				os.Exit(1)
+			s.fail()
+		} else {
+			s.add(dur)
+		}
+
+		switch {
+		case rw != nil:
+			emit(rw, probeRecord(target, msg, start, ph, err))
+		case err != nil:
+			fmt.Printf("fail in %s (%s): %v\n", ph.total(), classify(err), err)
+		case *showPhases:
+			fmt.Println(ph)
+		default:
+			// Print the time the probe took, such as to establish a TCP
+			// socket to a given host and port, and where from
+			if ph.persistent && ph.connect > 0 {
+				fmt.Printf("%s from %s, connected in %s\n", dur, ph.from(), ph.connect)
+			} else {
+				fmt.Printf("%s from %s\n", dur, ph.from())
+			}
+		}
+
+		select {
+		case <-interrupt:
+			break PROBES
+		default:
+		}
+	}
+
+	p.close()
+
+	if rw != nil {
+		emit(rw, summaryRecord(target, &s))
+	} else {
+		s.print(os.Stdout, target)
+	}
+
+	os.Exit(outcome(&s, *maxLoss, *maxLatency))
+}
+
+// pingMany pings targets concurrently, periodically redrawing a table of
+// their state, or writing the outcome of each attempt with rw if not nil.
+func pingMany(targets []string, rw recordWriter, interrupt <-chan os.Signal) {
+	p := &pool{workers: *workers, count: *count, interval: *interval}
+	for _, t := range targets {
+		p.targets = append(p.targets, &target{name: t, p: newProber(t)})
+	}
+
+	if rw != nil {
+		p.report = func(t *target, r result) {
+			emit(rw, probeRecord(t.name, t.stats.sent, r.start, r.ph, r.err))
+		}
+
+		p.run(interrupt, *refresh, func([]*target) {})
+
+		for _, t := range p.targets {
+			emit(rw, summaryRecord(t.name, &t.stats))
+		}
+	} else {
+		if *count <= 0 {
+			fmt.Println("CTRL+C to stop.")
+		}
+
+		tty := isTerminal(os.Stdout)
+
+		p.run(interrupt, *refresh, func(targets []*target) {
+			if tty {
+				// clear the screen and redraw the table in place
+				fmt.Print("\033[H\033[2J")
+			} else {
+				fmt.Println()
 			}
+			render(os.Stdout, targets)
+		})
+	}
+
+	code := exitOK
+	for _, t := range p.targets {
+		code = worst(code, outcome(&t.stats, *maxLoss, *maxLatency))
+	}
+
+	os.Exit(code)
+}
+
+// serveMetrics probes targets concurrently until interrupted, serving
+// metrics of their probes over HTTP and writing the outcome of each attempt
+// with rw if not nil.
+func serveMetrics(targets []string, rw recordWriter, interrupt <-chan os.Signal) {
+	m := newMetrics(defaultBuckets)
+
+	p := &pool{workers: *workers, interval: *interval}
+	for _, t := range targets {
+		p.targets = append(p.targets, &target{name: t, p: newProber(t)})
+		m.add(t)
+	}
+
+	p.report = func(t *target, r result) {
+		m.observe(t.name, r.ph, r.err)
+		if rw != nil {
+			emit(rw, probeRecord(t.name, t.stats.sent, r.start, r.ph, r.err))
+		}
+	}
+
+	l, err := net.Listen("tcp", *metricsAddr)
+	if err != nil {
+		log.Println(err)
+		os.Exit(exitError)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			log.Println(err)
+			os.Exit(exitError)
+		}
+	}()
+
+	log.Printf("serving metrics of %d targets on http://%s/metrics", len(targets), l.Addr())
+
+	p.run(interrupt, *refresh, func([]*target) {})
+
+	_ = srv.Close()
+}
+
+// sweepHost probes the swept ports of host, printing the state of each, or
+// writing the outcome of each probe with rw if not nil.
+func sweepHost(host string, rw recordWriter, interrupt <-chan os.Signal) {
+	ports, err := parsePorts(*sweepPorts)
+	if err != nil {
+		fmt.Print(err, "\n\n")
+		flag.Usage()
+		os.Exit(exitError)
+	}
+
+	if _, _, err = net.SplitHostPort(host); err == nil {
+		fmt.Printf("-sweep requires a host without a port: %s\n\n", host)
+		flag.Usage()
+		os.Exit(exitError)
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	p := newProber(net.JoinHostPort(host, strconv.Itoa(ports[0])))
+
+	dns, err := p.init()
+	if err != nil {
+		if rw != nil {
+			emit(rw, probeRecord(host, 1, time.Now(), phases{dns: dns}, err))
 		} else {
-			_ = c.Close()
-			// Print the time to establish a TCP socket to a given host and port
-			fmt.Println(dur)
-			break
+			fmt.Printf("SWEEP %s: fail in %s (%s): %v\n", host, dns, classify(err), err)
 		}
+		os.Exit(exitDown)
+	}
+
+	if rw == nil {
+		fmt.Printf("SWEEP %s: %d ports\n", host, len(ports))
+	}
+
+	results := sweep(p, host, ports, *workers, *rate, interrupt)
+
+	open := false
+	for _, r := range results {
+		if rw != nil {
+			emit(rw, probeRecord(net.JoinHostPort(host, strconv.Itoa(r.port)), 1, r.start, r.ph, r.err))
+		}
+		open = open || r.state() == portOpen
+	}
+
+	if rw == nil {
+		renderSweep(os.Stdout, results)
+	}
 
-		time.Sleep(*interval)
+	if !open {
+		os.Exit(exitDown)
 	}
 }
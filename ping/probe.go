@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// probe modes
+const (
+	modeTCP      = "tcp"      // time connecting and, optionally, a TLS handshake
+	modeUDP      = "udp"      // time the echo of a datagram
+	modePingPong = "pingpong" // time a ping's pong over a persistent TCP connection
+)
+
+// phases holds the time each phase of a probe took. Phases that were
+// skipped take no time.
+type phases struct {
+	dns     time.Duration // resolving the host name
+	connect time.Duration // the TCP handshake
+	tls     time.Duration // the TLS handshake
+	rtt     time.Duration // a request's round trip
+	addr    string        // the address connected to
+	local   string        // the local address connected from, if known
+	iface   string        // the network interface connected through, if chosen
+
+	// whether the request went over a persistent connection, which the
+	// probe only sometimes had to set up first
+	persistent bool
+}
+
+func (p phases) total() time.Duration {
+	return p.dns + p.connect + p.tls + p.rtt
+}
+
+// sample returns the latency the probe measured: the request's round trip
+// over a persistent connection, leaving out setting it up, or else the
+// total.
+func (p phases) sample() time.Duration {
+	if p.persistent {
+		return p.rtt
+	}
+
+	return p.total()
+}
+
+// from returns the local address and, if chosen, the network interface the
+// probe connected from.
+func (p phases) from() string {
+	if p.iface != "" {
+		return p.local + " via " + p.iface
+	}
+
+	return p.local
+}
+
+func (p phases) String() string {
+	s := fmt.Sprintf("dns %s, connect %s", p.dns, p.connect)
+	if p.tls > 0 {
+		s += fmt.Sprintf(", tls %s", p.tls)
+	}
+	if p.rtt > 0 {
+		s += fmt.Sprintf(", rtt %s", p.rtt)
+	}
+
+	if p.local != "" {
+		return s + fmt.Sprintf(", total %s (%s -> %s)", p.total(), p.from(), p.addr)
+	}
+
+	return s + fmt.Sprintf(", total %s (%s)", p.total(), p.addr)
+}
+
+// prober probes a target in one of the probe modes and times each phase.
+type prober struct {
+	target      string
+	mode        string        // modeTCP if empty
+	timeout     time.Duration // for the whole probe
+	tls         *tls.Config   // performs a TLS handshake after connecting over TCP, if not nil
+	resolveEach bool          // whether to resolve the host for every probe
+	family      string        // "4" or "6" to force IPv4 or IPv6, or "" for either
+	sourceIP    net.IP        // to connect from, if not nil
+	sourcePort  int           // to connect from, if not 0
+	iface       string        // network interface to connect through, if not ""
+	mark        int           // SO_MARK of the socket, if not 0
+	delay       bool          // whether to disable TCP_NODELAY
+
+	resolved string        // the address resolved once, if not resolveEach
+	seq      int           // of the last echo request or ping
+	conn     net.Conn      // the persistent connection in modePingPong
+	r        *bufio.Reader // of conn
+}
+
+// resolve looks up the target's host and returns the first address along
+// with the target's port. IP addresses take no time to resolve.
+func (p *prober) resolve(ctx context.Context) (string, time.Duration, error) {
+	host, port, err := net.SplitHostPort(p.target)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if p.family != "" && (ip.To4() != nil) != (p.family == "4") {
+			return "", 0, fmt.Errorf("%s is not an IPv%s address", host, p.family)
+		}
+		return p.target, 0, nil
+	}
+
+	start := time.Now()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip"+p.family, host)
+	dur := time.Since(start)
+	if err != nil {
+		return "", dur, err
+	}
+
+	return net.JoinHostPort(ips[0].String(), port), dur, nil
+}
+
+// dialer returns a dialer of network "tcp" or "udp" that connects from the
+// source address, if any, and applies the socket options.
+func (p *prober) dialer(network string) *net.Dialer {
+	d := &net.Dialer{Control: p.control}
+
+	if p.sourceIP != nil || p.sourcePort != 0 {
+		if network == "udp" {
+			d.LocalAddr = &net.UDPAddr{IP: p.sourceIP, Port: p.sourcePort}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: p.sourceIP, Port: p.sourcePort}
+		}
+	}
+
+	return d
+}
+
+// control applies the socket options to a socket before it connects.
+func (p *prober) control(_, _ string, c syscall.RawConn) error {
+	if p.sourcePort == 0 && p.iface == "" && p.mark == 0 {
+		return nil
+	}
+
+	var err error
+	cErr := c.Control(func(fd uintptr) {
+		// every probe, and concurrent probes of many targets or ports,
+		// bind the same source port
+		if p.sourcePort != 0 {
+			if err = reuseAddr(fd); err != nil {
+				return
+			}
+		}
+		if p.iface != "" {
+			if err = bindToDevice(fd, p.iface); err != nil {
+				return
+			}
+		}
+		if p.mark != 0 {
+			err = setMark(fd, p.mark)
+		}
+	})
+	if cErr != nil {
+		return cErr
+	}
+
+	return err
+}
+
+// init resolves the target once, unless it is resolved for every probe.
+func (p *prober) init() (time.Duration, error) {
+	if p.resolveEach {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	addr, dur, err := p.resolve(ctx)
+	if err != nil {
+		return dur, err
+	}
+	p.resolved = addr
+
+	return dur, nil
+}
+
+// probe probes the target in the prober's mode. The phases are valid up to
+// the one that failed.
+func (p *prober) probe() (phases, error) {
+	var ph phases
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	// a persistent connection needs no resolving
+	if p.conn != nil {
+		ph.addr, ph.local, ph.iface = p.conn.RemoteAddr().String(), p.conn.LocalAddr().String(), p.iface
+		return ph, p.pingPong(ctx, &ph)
+	}
+
+	ph.addr = p.resolved
+	if ph.addr == "" {
+		var err error
+		ph.addr, ph.dns, err = p.resolve(ctx)
+		if err != nil {
+			return ph, err
+		}
+	}
+
+	switch p.mode {
+	case modeUDP:
+		return ph, p.echo(ctx, &ph)
+	case modePingPong:
+		return ph, p.pingPong(ctx, &ph)
+	}
+
+	conn, err := p.connect(ctx, &ph)
+	if err != nil {
+		return ph, err
+	}
+
+	_ = conn.Close()
+
+	return ph, nil
+}
+
+// connect connects to the resolved address over TCP and performs the TLS
+// handshake if configured.
+func (p *prober) connect(ctx context.Context, ph *phases) (net.Conn, error) {
+	start := time.Now()
+	conn, err := p.dialer("tcp").DialContext(ctx, "tcp"+p.family, ph.addr)
+	ph.connect = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	ph.local, ph.iface = conn.LocalAddr().String(), p.iface
+
+	// reset the connection on closing it rather than leaving it in
+	// TIME_WAIT, which would keep the next probe from binding the port
+	if p.sourcePort != 0 {
+		if err = conn.(*net.TCPConn).SetLinger(0); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	// Go enables TCP_NODELAY once connected, overriding anything the
+	// dialer's Control function sets
+	if p.delay {
+		if err = conn.(*net.TCPConn).SetNoDelay(false); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if p.tls != nil {
+		tlsConn := tls.Client(conn, p.tls)
+
+		start = time.Now()
+		err = tlsConn.HandshakeContext(ctx)
+		ph.tls = time.Since(start)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	return conn, nil
+}
+
+// echo sends a datagram to the resolved address and waits for the same
+// datagram in reply, as an echo server sends.
+func (p *prober) echo(ctx context.Context, ph *phases) error {
+	conn, err := p.dialer("udp").DialContext(ctx, "udp"+p.family, ph.addr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	ph.local, ph.iface = conn.LocalAddr().String(), p.iface
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// unique, so the reply to an earlier probe that timed out isn't mistaken
+	// for this one's
+	p.seq++
+	req := []byte(fmt.Sprintf("ping %d %d", p.seq, time.Now().UnixNano()))
+	buf := make([]byte, 1024)
+
+	start := time.Now()
+	defer func() { ph.rtt = time.Since(start) }()
+
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	for {
+		// reading fails with ECONNREFUSED if the host replies that the
+		// port is unreachable
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(req, buf[:n]) {
+			return nil
+		}
+	}
+}
+
+// pingPong sends a "ping <seq>" line over the persistent connection, first
+// connecting if necessary, and waits for a "pong <seq>" line in reply. An
+// echoed ping is accepted too. The connection is closed if anything fails,
+// so the next probe reconnects.
+func (p *prober) pingPong(ctx context.Context, ph *phases) error {
+	ph.persistent = true
+
+	if p.conn == nil {
+		conn, err := p.connect(ctx, ph)
+		if err != nil {
+			return err
+		}
+		p.conn, p.r = conn, bufio.NewReader(conn)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = p.conn.SetDeadline(deadline)
+	}
+
+	p.seq++
+	ping := fmt.Sprintf("ping %d\n", p.seq)
+
+	start := time.Now()
+	err := func() error {
+		if _, err := p.conn.Write([]byte(ping)); err != nil {
+			return err
+		}
+
+		reply, err := p.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if reply != ping && reply != fmt.Sprintf("pong %d\n", p.seq) {
+			return fmt.Errorf("unexpected reply %q", reply)
+		}
+
+		return nil
+	}()
+	ph.rtt = time.Since(start)
+
+	if err != nil {
+		p.close()
+	}
+
+	return err
+}
+
+// close closes the persistent connection, if any.
+func (p *prober) close() {
+	if p.conn != nil {
+		_ = p.conn.Close()
+		p.conn, p.r = nil, nil
+	}
+}
+
+// parseSource parses a source address of an IP address, a port or both, as
+// in "192.0.2.1", ":8000" or "[2001:db8::1]:8000".
+func parseSource(s string) (net.IP, int, error) {
+	host, port := s, ""
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		host, port = h, p
+	}
+
+	var ip net.IP
+	if host != "" {
+		if ip = net.ParseIP(host); ip == nil {
+			return nil, 0, fmt.Errorf("invalid source IP address %q", host)
+		}
+	}
+
+	var n int
+	if port != "" {
+		var err error
+		if n, err = strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return nil, 0, fmt.Errorf("invalid source port %q", port)
+		}
+	}
+
+	return ip, n, nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen returns the address of a TCP listener that accepts and closes
+// connections.
+func listen(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestProberPhases(t *testing.T) {
+	addr := listen(t)
+	_, port, _ := net.SplitHostPort(addr)
+
+	tests := []struct {
+		name        string
+		target      string
+		resolveEach bool
+		dns         bool // whether each probe resolves the host
+	}{
+		{"ip", addr, true, false},
+		{"resolve once", "localhost:" + port, false, false},
+		{"resolve each", "localhost:" + port, true, true},
+	}
+
+	for _, test := range tests {
+		p := &prober{target: test.target, timeout: time.Second, resolveEach: test.resolveEach}
+		if _, err := p.init(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		ph, err := p.probe()
+		if err != nil {
+			// localhost may resolve to an address that isn't listening
+			if strings.HasPrefix(test.target, "localhost") {
+				t.Skipf("%s: %v", test.name, err)
+			}
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if (ph.dns > 0) != test.dns {
+			t.Errorf("%s: unexpected dns time %s", test.name, ph.dns)
+		}
+		if ph.connect <= 0 || ph.tls != 0 || ph.total() != ph.dns+ph.connect {
+			t.Errorf("%s: unexpected phases %v", test.name, ph)
+		}
+		if host, _, _ := net.SplitHostPort(ph.addr); net.ParseIP(host) == nil {
+			t.Errorf("%s: expected a resolved address; actual %q", test.name, ph.addr)
+		}
+	}
+}
+
+func TestProberTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	target := srv.Listener.Addr().String()
+
+	p := &prober{target: target, timeout: time.Second, tls: &tls.Config{InsecureSkipVerify: true}}
+	ph, err := p.probe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ph.tls <= 0 || !strings.Contains(ph.String(), "tls ") {
+		t.Errorf("expected a TLS handshake time; actual %v", ph)
+	}
+
+	// the handshake fails without trusting the test certificate
+	p.tls = &tls.Config{}
+	if _, err = p.probe(); err == nil {
+		t.Error("expected certificate verification to fail")
+	}
+
+	// a plain TCP server fails the handshake
+	p = &prober{target: listen(t), timeout: time.Second, tls: &tls.Config{InsecureSkipVerify: true}}
+	if ph, err = p.probe(); err == nil || ph.connect <= 0 {
+		t.Errorf("expected a failed handshake after connecting; actual %v, %v", ph, err)
+	}
+}
+
+// echoUDP returns the address of a UDP server that echoes datagrams unless
+// silent.
+func echoUDP(t *testing.T, silent bool) string {
+	t.Helper()
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if !silent {
+				_, _ = server.WriteTo(buf[:n], addr)
+			}
+		}
+	}()
+
+	return server.LocalAddr().String()
+}
+
+func TestProberUDP(t *testing.T) {
+	p := &prober{target: echoUDP(t, false), mode: modeUDP, timeout: time.Second}
+	for i := 0; i < 2; i++ {
+		ph, err := p.probe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ph.rtt <= 0 || ph.connect != 0 {
+			t.Errorf("expected only a round trip; actual %v", ph)
+		}
+	}
+
+	p = &prober{target: echoUDP(t, true), mode: modeUDP, timeout: 50 * time.Millisecond}
+	if _, err := p.probe(); classify(err) != classTimeout {
+		t.Errorf("expected a timeout; actual %v", err)
+	}
+
+	// nothing listens on a closed socket's port
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	p = &prober{target: closed, mode: modeUDP, timeout: time.Second}
+	if _, err = p.probe(); classify(err) != classRefused {
+		t.Errorf("expected a refusal; actual %v", err)
+	}
+}
+
+func TestProberPingPong(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	accepted := make(chan struct{}, 10)
+
+	// reply with a pong to each ping until asked for the third
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+
+			go func(c net.Conn) {
+				defer func() { _ = c.Close() }()
+
+				s := bufio.NewScanner(c)
+				for s.Scan() {
+					if s.Text() == "ping 3" {
+						continue
+					}
+					_, _ = fmt.Fprintf(c, "pong%s\n", strings.TrimPrefix(s.Text(), "ping"))
+				}
+			}(conn)
+		}
+	}()
+
+	p := &prober{target: l.Addr().String(), mode: modePingPong, timeout: 100 * time.Millisecond}
+	defer p.close()
+
+	for i := 1; i <= 4; i++ {
+		ph, err := p.probe()
+
+		// connecting is reported apart from the round trip
+		if err == nil && ph.sample() != ph.rtt {
+			t.Errorf("probe %d: expected a sample of the round trip %s; actual %s", i, ph.rtt, ph.sample())
+		}
+
+		switch i {
+		case 1:
+			if err != nil || ph.connect <= 0 || ph.rtt <= 0 {
+				t.Fatalf("expected to connect and ping; actual %v, %v", ph, err)
+			}
+			if r := probeRecord(p.target, i, time.Now(), ph, err); r.Duration != ph.rtt || r.Connect != ph.connect {
+				t.Errorf("expected a record of the round trip and connecting apart; actual %+v", r)
+			}
+		case 2:
+			if err != nil || ph.connect != 0 || ph.rtt <= 0 {
+				t.Errorf("expected to reuse the connection; actual %v, %v", ph, err)
+			}
+		case 3:
+			if classify(err) != classTimeout {
+				t.Errorf("expected a timeout; actual %v", err)
+			}
+		case 4:
+			if err != nil || ph.connect <= 0 {
+				t.Errorf("expected to reconnect; actual %v, %v", ph, err)
+			}
+		}
+	}
+
+	if len(accepted) != 2 {
+		t.Errorf("expected 2 connections; actual %d", len(accepted))
+	}
+}
+
+func TestProberSource(t *testing.T) {
+	addr := listen(t)
+
+	// a port that's free
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := l.Addr().String()
+	_ = l.Close()
+
+	ip, port, err := parseSource(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every probe binds the same port
+	p := &prober{target: addr, timeout: time.Second, sourceIP: ip, sourcePort: port, family: "4", delay: true}
+	for i := 0; i < 3; i++ {
+		ph, err := p.probe()
+		if err != nil {
+			t.Fatalf("probe %d: %v", i, err)
+		}
+		if ph.local != local || !strings.Contains(ph.String(), local+" -> "+addr) {
+			t.Errorf("probe %d: expected to connect from %s; actual %v", i, local, ph)
+		}
+	}
+
+	p = &prober{target: addr, timeout: time.Second, family: "6"}
+	if _, err = p.probe(); err == nil || !strings.Contains(err.Error(), "not an IPv6 address") {
+		t.Errorf("expected an error for an IPv4 target; actual %v", err)
+	}
+
+	_, addrPort, _ := net.SplitHostPort(addr)
+	p = &prober{target: "localhost:" + addrPort, timeout: time.Second, family: "4", resolveEach: true}
+	if ph, err := p.probe(); err != nil || ph.addr != addr {
+		t.Errorf("expected to connect to %s; actual %v, %v", addr, ph, err)
+	}
+}
+
+func TestProberInterface(t *testing.T) {
+	addr := listen(t)
+
+	p := &prober{target: addr, timeout: time.Second, iface: "lo"}
+	ph, err := p.probe()
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			t.Error("expected an error binding to an interface off Linux")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ph.iface != "lo" || !strings.Contains(ph.String(), " via lo -> "+addr) {
+		t.Errorf("expected to connect through lo; actual %v", ph)
+	}
+
+	p = &prober{target: addr, timeout: time.Second, iface: "nonexistent0"}
+	if _, err = p.probe(); err == nil {
+		t.Error("expected an error for an unknown interface")
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		source string
+		ip     string
+		port   int
+		ok     bool
+	}{
+		{"", "", 0, true},
+		{"192.0.2.1", "192.0.2.1", 0, true},
+		{":8000", "", 8000, true},
+		{"192.0.2.1:8000", "192.0.2.1", 8000, true},
+		{"2001:db8::1", "2001:db8::1", 0, true},
+		{"[2001:db8::1]:8000", "2001:db8::1", 8000, true},
+		{"example.com", "", 0, false},
+		{":http", "", 0, false},
+		{":70000", "", 0, false},
+	}
+
+	for _, test := range tests {
+		ip, port, err := parseSource(test.source)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: unexpected error %v", test.source, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+
+		if (test.ip == "" && ip != nil) || (test.ip != "" && !ip.Equal(net.ParseIP(test.ip))) || port != test.port {
+			t.Errorf("%q: expected %s and %d; actual %s and %d", test.source, test.ip, test.port, ip, port)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import "syscall"
+
+// setMark sets the SO_MARK of the socket fd, which policy routing and
+// firewall rules can match on. It requires CAP_NET_ADMIN.
+func setMark(fd uintptr, mark int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+}
+
+// bindToDevice restricts the socket fd to the network interface named
+// iface with SO_BINDTODEVICE, so it sends and receives through it alone.
+func bindToDevice(fd uintptr, iface string) error {
+	return syscall.BindToDevice(int(fd), iface)
+}
+
+// reuseAddr sets SO_REUSEADDR on the socket fd, so it can bind a source
+// port that other sockets are bound to.
+func reuseAddr(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func setMark(uintptr, int) error {
+	return errors.New("SO_MARK is only supported on Linux")
+}
+
+func bindToDevice(uintptr, string) error {
+	return errors.New("SO_BINDTODEVICE is only supported on Linux")
+}
+
+// reuseAddr does nothing, so probes from a fixed source port rely on
+// SO_LINGER alone to free the port for the next probe.
+func reuseAddr(uintptr) error {
+	return nil
+}
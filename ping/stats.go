@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/yourfavoritedev/go_networking/histogram"
+)
+
+// width of the bars of the histogram in the summary
+const histogramWidth = 40
+
+// stats summarizes the outcome of a series of probes.
+type stats struct {
+	sent      int
+	succeeded int
+	min, max  time.Duration
+	sum       float64 // of the durations of successful probes, in nanoseconds
+	sumSq     float64 // of their squares
+	hist      histogram.Histogram
+}
+
+// add records a successful probe that took d.
+func (s *stats) add(d time.Duration) {
+	s.sent++
+	s.succeeded++
+
+	if s.succeeded == 1 || d < s.min {
+		s.min = d
+	}
+	if d > s.max {
+		s.max = d
+	}
+
+	s.sum += float64(d)
+	s.sumSq += float64(d) * float64(d)
+	s.hist.Record(d)
+}
+
+// fail records a failed probe.
+func (s *stats) fail() {
+	s.sent++
+}
+
+func (s *stats) failed() int {
+	return s.sent - s.succeeded
+}
+
+// loss returns the percentage of failed probes.
+func (s *stats) loss() float64 {
+	if s.sent == 0 {
+		return 0
+	}
+
+	return 100 * float64(s.failed()) / float64(s.sent)
+}
+
+func (s *stats) avg() time.Duration {
+	if s.succeeded == 0 {
+		return 0
+	}
+
+	return time.Duration(s.sum / float64(s.succeeded))
+}
+
+// stddev returns the population standard deviation of the successful
+// probes' durations.
+func (s *stats) stddev() time.Duration {
+	if s.succeeded == 0 {
+		return 0
+	}
+
+	n := float64(s.succeeded)
+	mean := s.sum / n
+
+	// rounding may make the variance slightly negative
+	variance := math.Max(s.sumSq/n-mean*mean, 0)
+
+	return time.Duration(math.Sqrt(variance))
+}
+
+// print writes the summary of the probes of target to w.
+func (s *stats) print(w io.Writer, target string) {
+	fmt.Fprintf(w, "\n--- %s ping statistics ---\n", target)
+	fmt.Fprintf(w, "%d probes sent, %d succeeded, %d failed, %.1f%% loss\n",
+		s.sent, s.succeeded, s.failed(), s.loss())
+
+	if s.succeeded > 0 {
+		fmt.Fprintf(w, "connect min/avg/max/stddev = %s/%s/%s/%s\n",
+			s.min, s.avg(), s.max, s.stddev())
+		fmt.Fprintf(w, "connect p50/p90/p99/p99.9 = %s/%s/%s/%s\n\n",
+			s.hist.Quantile(.5), s.hist.Quantile(.9), s.hist.Quantile(.99), s.hist.Quantile(.999))
+		s.hist.Print(w, histogramWidth)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	var s stats
+	for _, d := range []time.Duration{2, 4, 4, 4, 5, 5, 7, 9} {
+		s.add(d * time.Millisecond)
+	}
+	s.fail()
+	s.fail()
+
+	if s.sent != 10 || s.succeeded != 8 || s.failed() != 2 {
+		t.Errorf("expected 10 sent, 8 succeeded, 2 failed; actual %d, %d, %d",
+			s.sent, s.succeeded, s.failed())
+	}
+
+	if s.loss() != 20 {
+		t.Errorf("expected 20%% loss; actual %f", s.loss())
+	}
+
+	if s.hist.Count() != 8 || s.hist.Quantile(.5) < 3900*time.Microsecond || s.hist.Quantile(.5) > 4100*time.Microsecond {
+		t.Errorf("expected a histogram of 8 durations with a median of about 4ms; actual %d, %s",
+			s.hist.Count(), s.hist.Quantile(.5))
+	}
+
+	expected := []time.Duration{2 * time.Millisecond, 5 * time.Millisecond, 9 * time.Millisecond, 2 * time.Millisecond}
+	actual := []time.Duration{s.min, s.avg(), s.max, s.stddev()}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Errorf("expected min/avg/max/stddev %v; actual %v", expected, actual)
+			break
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	s.print(buf, "example.com:80")
+
+	for _, line := range []string{
+		"--- example.com:80 ping statistics ---",
+		"10 probes sent, 8 succeeded, 2 failed, 20.0% loss",
+		"connect min/avg/max/stddev = 2ms/5ms/9ms/2ms",
+		"connect p50/p90/p99/p99.9 = ",
+		"|" + strings.Repeat("#", histogramWidth) + "| 3\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("expected %q in summary:\n%s", line, buf)
+		}
+	}
+}
+
+func TestStatsNoReplies(t *testing.T) {
+	var s stats
+	s.fail()
+
+	buf := new(bytes.Buffer)
+	s.print(buf, "example.com:80")
+
+	if s.loss() != 100 || strings.Contains(buf.String(), "min/avg/max") {
+		t.Errorf("expected 100%% loss and no times; actual:\n%s", buf)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// states of a port in a sweep
+const (
+	portOpen     = "open"     // something accepted the connection
+	portClosed   = "closed"   // the host refused the connection
+	portFiltered = "filtered" // nothing replied or the host is unreachable
+	portError    = "error"    // the probe failed otherwise
+)
+
+// parsePorts parses a comma-separated list of ports and ranges of ports, as
+// in "22,80,8000-8100", returning the ports in order without duplicates.
+func parsePorts(s string) ([]int, error) {
+	seen := make(map[int]bool)
+
+	for _, item := range strings.Split(s, ",") {
+		first, last := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			first, last = item[:i], item[i+1:]
+		}
+
+		from, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parsePort(last)
+		if err != nil {
+			return nil, err
+		}
+		if from > to {
+			return nil, fmt.Errorf("invalid port range %q", item)
+		}
+
+		for port := from; port <= to; port++ {
+			seen[port] = true
+		}
+	}
+
+	ports := make([]int, 0, len(seen))
+	for port := range seen {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+
+	return port, nil
+}
+
+// portState returns the state of a port given the error connecting to it.
+func portState(err error) string {
+	switch classify(err) {
+	case "":
+		return portOpen
+	case classRefused:
+		return portClosed
+	case classTimeout, classUnreachable:
+		return portFiltered
+	default:
+		return portError
+	}
+}
+
+// sweepResult is the outcome of probing a port.
+type sweepResult struct {
+	port  int
+	start time.Time
+	ph    phases
+	err   error
+}
+
+func (r sweepResult) state() string {
+	return portState(r.err)
+}
+
+// sweep probes ports of host concurrently, each with a copy of base, which
+// is already initialized. At most workers probes are in flight and, unless
+// rate is 0 or less, at most rate probes start each second. It returns the
+// results in the order of ports, stopping early if stop receives.
+func sweep(base *prober, host string, ports []int, workers, rate int, stop <-chan os.Signal) []sweepResult {
+	results := make([]sweepResult, len(ports))
+
+	// the address resolved once, without its port
+	var ip string
+	if base.resolved != "" {
+		ip, _, _ = net.SplitHostPort(base.resolved)
+	}
+
+	jobs := make(chan int)
+	done := make(chan struct{}, len(ports))
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for i := range jobs {
+				p := *base
+				p.target = net.JoinHostPort(host, strconv.Itoa(ports[i]))
+				if ip != "" {
+					p.resolved = net.JoinHostPort(ip, strconv.Itoa(ports[i]))
+				}
+
+				r := sweepResult{port: ports[i], start: time.Now()}
+				r.ph, r.err = p.probe()
+				results[i] = r
+				done <- struct{}{}
+			}
+		}()
+	}
+
+	// rates beyond a probe per nanosecond are unlimited
+	var limit <-chan time.Time
+	if rate > 0 && rate <= int(time.Second) {
+		t := time.NewTicker(time.Second / time.Duration(rate))
+		defer t.Stop()
+		limit = t.C
+	}
+
+	// ports are dispatched in order, so the first n have results
+	n := 0
+
+DISPATCH:
+	for i := range ports {
+		if limit != nil && i > 0 {
+			select {
+			case <-limit:
+			case <-stop:
+				break DISPATCH
+			}
+		}
+
+		select {
+		case jobs <- i:
+			n++
+		case <-stop:
+			break DISPATCH
+		}
+	}
+	close(jobs)
+
+	for i := 0; i < n; i++ {
+		<-done
+	}
+
+	return results[:n]
+}
+
+// renderSweep writes a table of the state of each port to w, followed by
+// the number of ports in each state.
+func renderSweep(w io.Writer, results []sweepResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PORT\tSTATE\tLATENCY\tERROR")
+
+	counts := make(map[string]int)
+
+	for _, r := range results {
+		state := r.state()
+		counts[state]++
+
+		latency, msg := "-", ""
+		switch state {
+		case portOpen, portClosed:
+			// a refusal takes a round trip too
+			latency = r.ph.connect.String()
+		case portError:
+			msg = r.err.Error()
+		}
+
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", r.port, state, latency, msg)
+	}
+
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintf(w, "\n%d ports swept: %d open, %d closed, %d filtered, %d failed\n",
+		len(results), counts[portOpen], counts[portClosed], counts[portFiltered], counts[portError])
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePorts(t *testing.T) {
+	ports, err := parsePorts("8080, 22,80-82,81")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int{22, 80, 81, 82, 8080}
+	if !reflect.DeepEqual(expected, ports) {
+		t.Errorf("expected %v; actual %v", expected, ports)
+	}
+
+	for _, s := range []string{"", "0", "65536", "http", "90-80", "1-", "1,,2"} {
+		if _, err = parsePorts(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	var ports []int
+	open := make(map[int]bool)
+
+	// alternate open ports and closed ones
+	for i := 0; i < 6; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		ports = append(ports, port)
+
+		if i%2 == 0 {
+			open[port] = true
+			defer func() { _ = l.Close() }()
+		} else {
+			_ = l.Close()
+		}
+	}
+
+	p := &prober{target: net.JoinHostPort("127.0.0.1", strconv.Itoa(ports[0])), timeout: time.Second}
+	if _, err := p.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	results := sweep(p, "127.0.0.1", ports, 2, 100, nil)
+
+	// the first probe starts at once, the rest 10ms apart
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the rate to be limited; swept in %s", elapsed)
+	}
+
+	if len(results) != len(ports) {
+		t.Fatalf("expected %d results; actual %d", len(ports), len(results))
+	}
+
+	for i, r := range results {
+		expected := portClosed
+		if open[ports[i]] {
+			expected = portOpen
+		}
+		if r.port != ports[i] || r.state() != expected {
+			t.Errorf("expected port %d %s; actual port %d %s (%v)", ports[i], expected, r.port, r.state(), r.err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	renderSweep(buf, results)
+
+	if !strings.Contains(buf.String(), "6 ports swept: 3 open, 3 closed, 0 filtered, 0 failed") {
+		t.Errorf("unexpected summary:\n%s", buf)
+	}
+}
+
+func TestSweepStop(t *testing.T) {
+	p := &prober{target: listen(t), timeout: time.Second}
+	if _, err := p.init(); err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(p.target)
+	n, _ := strconv.Atoi(port)
+
+	stop := make(chan os.Signal, 1)
+	time.AfterFunc(50*time.Millisecond, func() { stop <- os.Interrupt })
+
+	// a probe every 20ms would take 2s without stopping
+	ports := make([]int, 100)
+	for i := range ports {
+		ports[i] = n
+	}
+	results := sweep(p, "127.0.0.1", ports, 4, 50, stop)
+
+	if len(results) == 0 || len(results) > 10 {
+		t.Errorf("expected a few results before stopping; actual %d", len(results))
+	}
+	for _, r := range results {
+		if r.state() != portOpen {
+			t.Errorf("expected port %d open; actual %s", r.port, r.state())
+		}
+	}
+}
+
+func TestPortState(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{nil, portOpen},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, portFiltered},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, portError},
+	}
+
+	for _, test := range tests {
+		if actual := portState(test.err); actual != test.expected {
+			t.Errorf("expected %s for %v; actual %s", test.expected, test.err, actual)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ft "github.com/yourfavoritedev/go_networking/file-transfer"
+)
+
+func usage() {
+	fmt.Printf(`Usage:
+  %[1]s serve [options] root
+  %[1]s get [options] host:port file [local file]
+  %[1]s put [options] host:port local-file [file]
+
+Run %[1]s <command> -h for the options of each command.
+`, os.Args[0])
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	var err error
+
+	switch os.Args[1] {
+	case "serve":
+		err = serve(os.Args[2:])
+	case "get":
+		err = get(os.Args[2:])
+	case "put":
+		err = put(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	default:
+		fmt.Printf("unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var (
+		addr      = fs.String("a", ":69", "listen address")
+		writable  = fs.Bool("w", false, "accept uploads into the root directory")
+		window    = fs.Uint("window", 64, "largest window size to negotiate")
+		retries   = fs.Uint("retries", 10, "number of times to retry a transmission")
+		timeout   = fs.Duration("timeout", 6*time.Second, "initial time to wait for an acknowledgment")
+		allow     = fs.String("allow", "", "comma-separated networks allowed to connect, e.g. 10.0.0.0/8")
+		deny      = fs.String("deny", "", "comma-separated networks refused")
+		readOnly  = fs.String("read-only", "", "comma-separated path patterns that may not be written")
+		max       = fs.Int("max", 0, "concurrent transfers in total: 0 means no limit")
+		maxPerIP  = fs.Int("max-per-ip", 0, "concurrent transfers per client: 0 means no limit")
+		statusInt = fs.Duration("status", 0, "interval between logs of the active transfers: 0 disables")
+		audit     = fs.String("audit", "", "file to record every request in as JSON lines")
+		auditSize = fs.Int64("audit-size", 100<<20, "size in bytes at which the audit file is rotated: 0 disables")
+	)
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s serve [options] root\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	root := fs.Arg(0)
+
+	if info, err := os.Stat(root); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", root)
+	}
+
+	if *window == 0 || *window > 65535 || *retries == 0 || *retries > 255 {
+		return fmt.Errorf("window must be 1-65535 and retries 1-255")
+	}
+
+	s := &ft.Server{
+		Root:       ft.DirFS(root),
+		Retries:    uint8(*retries),
+		Timeout:    *timeout,
+		WindowSize: uint16(*window),
+	}
+
+	if *writable {
+		s.Write = ft.DirWriter(root)
+	}
+
+	var err error
+	if s.Policy.Allow, err = networks(*allow); err != nil {
+		return err
+	}
+	if s.Policy.Deny, err = networks(*deny); err != nil {
+		return err
+	}
+	for _, pattern := range list(*readOnly) {
+		s.Policy.Paths = append(s.Policy.Paths, ft.PathRule{Pattern: pattern, Perm: ft.PermRead})
+	}
+	s.Policy.MaxTransfers = *max
+	s.Policy.MaxTransfersPerIP = *maxPerIP
+
+	if *audit != "" {
+		a, err := ft.NewAuditFile(*audit, *auditSize)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = a.Close() }()
+		s.Audit = a
+	}
+
+	s.Hooks.Done = func(info ft.TransferInfo, err error) {
+		if err != nil {
+			log.Printf("[%s] %s failed after %s: %v", info.Remote, info.Filename,
+				info.Stats.Duration.Round(time.Millisecond), err)
+		}
+	}
+
+	if *statusInt > 0 {
+		go func() {
+			for range time.Tick(*statusInt) {
+				for _, info := range s.Transfers() {
+					log.Printf("[%s] %s: %s", info.Remote, info.Filename, status(info))
+				}
+			}
+		}()
+	}
+
+	return s.ListenAndServe(*addr)
+}
+
+func get(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	c := clientFlags(fs)
+	verify := fs.Bool("verify", false, "verify the file against the server's SHA-256 digest")
+	resume := fs.Bool("resume", false, "append to a partial local file, and keep it if the download fails")
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s get [options] host:port file [local file]\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 2 || fs.NArg() > 3 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	addr, filename := fs.Arg(0), fs.Arg(1)
+	local := filepath.Base(filepath.FromSlash(strings.ReplaceAll(filename, `\`, "/")))
+	if fs.NArg() == 3 {
+		local = fs.Arg(2)
+	}
+
+	client, err := c()
+	if err != nil {
+		return err
+	}
+	client.Verify = *verify
+
+	var stats ft.Stats
+	if *resume {
+		stats, err = client.Resume(addr, filename, local)
+		fmt.Println()
+
+		if err != nil {
+			return err
+		}
+	} else {
+		// download beside the local file and replace it only once the
+		// download succeeds, so a failure leaves any existing file intact
+		f, err := os.CreateTemp(filepath.Dir(local), ".tftp-*")
+		if err != nil {
+			return err
+		}
+
+		stats, err = client.Get(addr, filename, f)
+		if err == nil {
+			err = f.Chmod(0o644)
+		}
+		if cErr := f.Close(); err == nil {
+			err = cErr
+		}
+		fmt.Println()
+
+		if err == nil {
+			err = os.Rename(f.Name(), local)
+		}
+		if err != nil {
+			_ = os.Remove(f.Name())
+			return err
+		}
+	}
+
+	fmt.Printf("received %s: %s\n", local, summary(stats))
+
+	return nil
+}
+
+func put(args []string) error {
+	fs := flag.NewFlagSet("put", flag.ExitOnError)
+	c := clientFlags(fs)
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s put [options] host:port local-file [file]\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 2 || fs.NArg() > 3 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	addr, local := fs.Arg(0), fs.Arg(1)
+	filename := filepath.Base(local)
+	if fs.NArg() == 3 {
+		filename = fs.Arg(2)
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	client, err := c()
+	if err != nil {
+		return err
+	}
+
+	stats, err := client.Put(addr, filename, f)
+	fmt.Println()
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("sent %s: %s\n", local, summary(stats))
+
+	return nil
+}
+
+// clientFlags defines the options shared by get and put on fs. The
+// returned function builds the client once fs is parsed.
+func clientFlags(fs *flag.FlagSet) func() (ft.Client, error) {
+	var (
+		mode     = fs.String("mode", ft.ModeOctet, "transfer mode: octet or netascii")
+		window   = fs.Uint("window", 1, "window size to request: 1 means lock-step")
+		rollover = fs.Uint("rollover", 0, "block number following 65535: 0 or 1")
+		retries  = fs.Uint("retries", 10, "number of times to retry a transmission")
+		timeout  = fs.Duration("timeout", 6*time.Second, "initial time to wait for the server")
+		quiet    = fs.Bool("q", false, "don't print progress")
+	)
+
+	return func() (ft.Client, error) {
+		if *window == 0 || *window > 65535 || *retries == 0 || *retries > 255 || *rollover > 1 {
+			return ft.Client{}, fmt.Errorf("window must be 1-65535, retries 1-255 and rollover 0 or 1")
+		}
+
+		c := ft.Client{
+			Mode:       *mode,
+			Retries:    uint8(*retries),
+			Timeout:    *timeout,
+			WindowSize: uint16(*window),
+			Rollover:   uint16(*rollover),
+		}
+
+		if !*quiet {
+			// redraw the progress line at most every 100ms
+			var last time.Time
+			c.Hooks.Progress = func(info ft.TransferInfo) {
+				if time.Since(last) >= 100*time.Millisecond {
+					last = time.Now()
+					fmt.Printf("\r%s: %s", info.Filename, status(info))
+				}
+			}
+		}
+
+		return c, nil
+	}
+}
+
+// status describes the progress of a transfer on a single line.
+func status(info ft.TransferInfo) string {
+	return fmt.Sprintf("%d bytes in %s, %d retransmits",
+		info.Stats.Bytes, info.Stats.Duration.Round(time.Millisecond), info.Stats.Retransmits)
+}
+
+// summary describes a completed transfer.
+func summary(stats ft.Stats) string {
+	return fmt.Sprintf("%d bytes in %s (%.1f KiB/s), %d retransmits, srtt %s",
+		stats.Bytes, stats.Duration.Round(time.Millisecond), stats.Throughput()/1024,
+		stats.Retransmits, stats.SRTT.Round(time.Microsecond))
+}
+
+// networks parses a comma-separated list of networks.
+func networks(s string) ([]*net.IPNet, error) {
+	return ft.ParseNetworks(list(s)...)
+}
+
+// list splits a comma-separated list, ignoring empty elements.
+func list(s string) []string {
+	var elems []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			elems = append(elems, e)
+		}
+	}
+
+	return elems
+}